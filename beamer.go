@@ -0,0 +1,183 @@
+package latex
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// Mode selects the kind of LaTeX output produced by the Renderer.
+type Mode uint8
+
+const (
+	// Document renders an article, report or book. It is the default mode.
+	Document Mode = iota
+	// Beamer renders a beamer slide deck: H1 becomes \section and H2 or a
+	// thematic break starts a new frame.
+	Beamer
+)
+
+// DefaultBeamerPreamble returns a copy of the default preamble used in Beamer mode.
+// It does not include \begin{document} text within, as expected by Config.Preamble.
+func DefaultBeamerPreamble() []byte {
+	r := &Renderer{Config: Config{Mode: Beamer}}
+	return []byte(r.TemplateData(nil, nil).Preamble)
+}
+
+var (
+	frameStart = []byte("\n\\begin{frame}")
+	frameEnd   = []byte("\n\\end{frame}\n")
+	noteStart  = []byte("\n\\note{")
+	notePrefix = []byte("Note:")
+)
+
+// block wraps the render function of a block node with behaviour common to all blocks.
+func (r *Renderer) block(fn renderer.NodeRendererFunc) renderer.NodeRendererFunc {
+	return func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering && r.Config.Mode == Beamer && r.isFrameContent(n) && !r.frameOpen(n.PreviousSibling()) {
+			// Content outside of a frame is not typeset by beamer, start an untitled frame.
+			r.writeFrameStart(w, n)
+			_ = w.WriteByte('\n')
+		}
+		lang := r.nodeLanguage(n)
+		if lang != "" && entering {
+			_, _ = w.WriteString("\n\\begin{otherlanguage}{" + lang + "}")
+		} else if entering {
+			r.diagnoseLanguage(source, n)
+		}
+		if entering && r.Config.LineMarkers {
+			writeLineMarker(w, source, nodeOffset(n))
+		}
+		status, err := fn(w, source, n, entering)
+		if lang != "" && !entering {
+			_, _ = w.WriteString("\n\\end{otherlanguage}\n")
+		}
+		return status, err
+	}
+}
+
+// beamerLevel returns the heading level of n after applying the configured offset.
+func (r *Renderer) beamerLevel(n *ast.Heading) int {
+	return max(1, n.Level+r.Config.HeadingLevelOffset)
+}
+
+// isFrameContent reports whether n is a top level block that is typeset inside a frame.
+func (r *Renderer) isFrameContent(n ast.Node) bool {
+	if n.Parent() == nil || n.Parent().Kind() != ast.KindDocument {
+		return false
+	}
+	switch n.Kind() {
+	case ast.KindThematicBreak:
+		return false
+	case ast.KindHeading:
+		return r.beamerLevel(n.(*ast.Heading)) > 2
+	}
+	return true
+}
+
+// frameOpen reports whether a frame is left open after the top level node prev.
+// Frames are closed by sections (H1), so any other node leaves a frame open.
+func (r *Renderer) frameOpen(prev ast.Node) bool {
+	if prev == nil {
+		return false
+	}
+	h, ok := prev.(*ast.Heading)
+	return !ok || r.beamerLevel(h) != 1
+}
+
+// frameFragile reports whether the frame started at n contains verbatim content,
+// which requires the frame to be declared fragile.
+func (r *Renderer) frameFragile(n ast.Node) bool {
+	for c := n; c != nil; c = c.NextSibling() {
+		if c != n && !r.isFrameContent(c) {
+			break
+		}
+		fragile := false
+		ast.Walk(c, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			kind := n.Kind()
+			if kind == ast.KindCodeBlock || kind == ast.KindFencedCodeBlock {
+				fragile = true
+				return ast.WalkStop, nil
+			}
+			return ast.WalkContinue, nil
+		})
+		if fragile {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Renderer) writeFrameStart(w util.BufWriter, n ast.Node) {
+	_, _ = w.Write(frameStart)
+	if r.frameFragile(n) {
+		_, _ = w.WriteString("[fragile]")
+	}
+}
+
+func (r *Renderer) renderBeamerHeading(w util.BufWriter, source []byte, n *ast.Heading, entering bool) (ast.WalkStatus, error) {
+	level := r.beamerLevel(n)
+	switch {
+	case level > 2:
+		if entering {
+			_, _ = w.Write(headingTable[headingBold][0])
+		} else {
+			_ = w.WriteByte('}')
+			_, _ = w.Write(hardBreak)
+		}
+	case entering:
+		if r.frameOpen(n.PreviousSibling()) {
+			_, _ = w.Write(frameEnd)
+		}
+		if level == 1 {
+			_, _ = w.WriteString("\n\\section{")
+		} else {
+			r.writeFrameStart(w, n)
+			_ = w.WriteByte('{')
+		}
+	default:
+		_, _ = w.WriteString("}\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *Renderer) renderBeamerThematicBreak(w util.BufWriter, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		if r.frameOpen(n.PreviousSibling()) {
+			_, _ = w.Write(frameEnd)
+		}
+		r.writeFrameStart(w, n)
+		_ = w.WriteByte('\n')
+	}
+	return ast.WalkContinue, nil
+}
+
+// isNote reports whether the blockquote starts with "Note:" and should
+// therefore be rendered as speaker notes.
+func isNote(source []byte, n ast.Node) bool {
+	p := n.FirstChild()
+	if p == nil || p.Kind() != ast.KindParagraph {
+		return false
+	}
+	t, ok := p.FirstChild().(*ast.Text)
+	return ok && bytes.HasPrefix(t.Segment.Value(source), notePrefix)
+}
+
+// notePrefixLen returns the length of the "Note:" prefix and following spaces
+// if n is the first text of a speaker note blockquote, or 0 otherwise.
+func (r *Renderer) notePrefixLen(source []byte, n *ast.Text) int {
+	if r.Config.Mode != Beamer || n.PreviousSibling() != nil {
+		return 0
+	}
+	p := n.Parent()
+	if p == nil || p.PreviousSibling() != nil || p.Parent() == nil || p.Parent().Kind() != ast.KindBlockquote {
+		return 0
+	}
+	value := n.Segment.Value(source)
+	if !bytes.HasPrefix(value, notePrefix) {
+		return 0
+	}
+	return len(value) - len(bytes.TrimLeft(value[len(notePrefix):], " \t"))
+}
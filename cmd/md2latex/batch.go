@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	latex "github.com/soypat/goldmark-latex"
+)
+
+// job is a markdown file to convert, its output filename and renderer configuration.
+type job struct {
+	filename, outname string
+	cfg               latex.Config
+}
+
+// runBatch converts the markdown files in args and under recurseDir in parallel
+// using up to jobs workers. It prints a summary and returns an error if any file failed.
+func runBatch(args []string) error {
+	if outputFilename != "" {
+		return errors.New("-o can not be used with several input files, use -outdir")
+	}
+	if print {
+		return errors.New("-p can not be used with several input files")
+	}
+	var work []job
+	for _, filename := range args {
+		if filename == "-" {
+			return errors.New("stdin can not be read with several input files")
+		}
+		outname := texFilename(filename)
+		if outputDir != "" {
+			outname = filepath.Join(outputDir, filepath.Base(outname))
+		}
+		work = append(work, job{filename: filename, outname: outname})
+	}
+	if recurseDir != "" {
+		err := filepath.WalkDir(recurseDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".md") {
+				return err
+			}
+			outname := texFilename(path)
+			if outputDir != "" {
+				rel, err := filepath.Rel(recurseDir, outname)
+				if err != nil {
+					return err
+				}
+				outname = filepath.Join(outputDir, rel)
+			}
+			work = append(work, job{filename: path, outname: outname})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Files with the same base name in different directories would be written
+	// to the same output file when flattened into -outdir.
+	outputs := make(map[string]string, len(work))
+	for _, j := range work {
+		outname := filepath.Clean(j.outname)
+		if other, ok := outputs[outname]; ok {
+			return fmt.Errorf("%s and %s are both converted to %s", other, j.filename, outname)
+		}
+		outputs[outname] = j.filename
+	}
+
+	// Configurations are resolved before starting workers as per file
+	// overrides are applied to flags.
+	for i := range work {
+		cfg, err := configFor(flag.CommandLine, work[i].filename)
+		if err != nil {
+			return err
+		}
+		work[i].cfg = cfg
+	}
+
+	start := time.Now()
+	errs := make([]error, len(work))
+	var wg sync.WaitGroup
+	workers := jobs
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	for i, j := range work {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, j job) {
+			defer func() { <-sem; wg.Done() }()
+			err := os.MkdirAll(filepath.Dir(j.outname), 0777)
+			if err == nil {
+				verb("converting", j.filename, "to", j.outname)
+				err = convert(j.cfg, j.filename, j.outname)
+			}
+			errs[i] = err
+		}(i, j)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			if !strings.Contains(err.Error(), work[i].filename) {
+				err = fmt.Errorf("%s: %w", work[i].filename, err)
+			}
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "converted %d files, %d failed in %v\n", len(work)-failed, failed, time.Since(start).Round(time.Millisecond))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(work))
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	latex "github.com/soypat/goldmark-latex"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// chapter is a markdown input file of a book.
+type chapter struct {
+	path     string // Path of the markdown file as given.
+	filename string // Base name of the markdown file.
+	name     string // Name of the .tex file without extension. Also used as label prefix.
+	source   []byte
+	doc      ast.Node
+}
+
+// runBook renders each markdown file to its own .tex file and writes a master
+// file with a shared preamble that \include's them. Links between files
+// are resolved to heading labels.
+func runBook(filenames []string) error {
+	cfg, err := latexConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.DocumentClass == "" {
+		cfg.DocumentClass = latex.ClassBook
+	}
+	master := outputFilename
+	if master == "" {
+		master = "book.tex"
+	}
+	dir := filepath.Dir(master)
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	ext, err := extensionConfig()
+	if err != nil {
+		return err
+	}
+	ext.Config = cfg
+	md := goldmark.New(goldmark.WithExtensions(latex.Extension(ext)))
+
+	// Parse all chapters first to build the label table.
+	labels := make(map[string]string)
+	chapters := make([]chapter, len(filenames))
+	names := make(map[string]string, len(filenames))
+	for i, filename := range filenames {
+		// Chapters with the same name would overwrite each other's .tex file and labels.
+		name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("%s and %s are both converted to %s", other, filename, filepath.Join(dir, name+".tex"))
+		}
+		names[name] = filename
+		source, err := readFile(filename)
+		if err != nil {
+			return err
+		}
+		ch := chapter{
+			path:     filename,
+			filename: filepath.Base(filename),
+			name:     name,
+			source:   source,
+			doc:      md.Parser().Parse(text.NewReader(source)),
+		}
+		labels[ch.filename] = ch.name
+		ast.Walk(ch.doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			if h, ok := n.(*ast.Heading); ok && entering {
+				if id, ok := h.AttributeString("id"); ok {
+					label := ch.name + ":" + string(id.([]byte))
+					if h == ch.doc.FirstChild() && h.Level == 1 {
+						labels[ch.filename] = label
+					}
+					labels[ch.filename+"#"+string(id.([]byte))] = label
+				}
+			}
+			return ast.WalkContinue, nil
+		})
+		chapters[i] = ch
+	}
+
+	var includes bytes.Buffer
+	var lost error
+	maps := make(map[string]latex.SourceMap)
+	for _, ch := range chapters {
+		var diags latex.DiagnosticCollector
+		chcfg, err := configFor(flag.CommandLine, ch.path)
+		if err != nil {
+			return err
+		}
+		// Chapters are part of a single document.
+		chcfg.DocumentClass = cfg.DocumentClass
+		chcfg.OnDiagnostic = diags.Report
+		chcfg.Fragment = true
+		chcfg.LabelPrefix = ch.name + ":"
+		chcfg.ResolveLink = bookLinkResolver(labels, ch.filename)
+		rd := renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(latex.NewRenderer(chcfg), 1000)))
+		var b bytes.Buffer
+		if h, ok := ch.doc.FirstChild().(*ast.Heading); !ok || h.Level != 1 {
+			// No leading top level heading, name the chapter after the file.
+			if cfg.DocumentClass.HasChapters() {
+				b.WriteString("\\chapter{")
+			} else {
+				b.WriteString("\\section{")
+			}
+			b.WriteString(latex.Escape(ch.name))
+			b.WriteString("}\\label{")
+			b.WriteString(ch.name)
+			b.WriteString("}\n")
+		}
+		err = rd.Render(&b, ch.source, ch.doc)
+		if err != nil {
+			return err
+		}
+		if err := reportDiagnostics(ch.path, diags.Diagnostics()); err != nil && lost == nil {
+			lost = err
+		}
+		if check {
+			continue
+		}
+		texname := filepath.Join(dir, ch.name+".tex")
+		output := b.Bytes()
+		if sourceMap || pdf {
+			var sm latex.SourceMap
+			output, sm = latex.ExtractSourceMap(output, lineMarkers)
+			sm.Source = ch.path
+			maps[filepath.Base(texname)] = sm
+		}
+		if sourceMap {
+			err = writeSourceMap(texname, maps[filepath.Base(texname)])
+			if err != nil {
+				return err
+			}
+		}
+		verb("writing chapter", texname)
+		err = os.WriteFile(texname, output, 0666)
+		if err != nil {
+			return err
+		}
+		includes.WriteString("\\include{" + ch.name + "}\n")
+	}
+
+	if check {
+		return lost
+	}
+	if !strict {
+		lost = nil
+	}
+	// The master preamble is built for the chapters taken together: it loads the
+	// packages and declares the characters and languages used by any chapter.
+	// Front matter is not parsed as the metadata of the first chapter is not the book's.
+	var source []byte
+	for _, ch := range chapters {
+		source = append(append(source, ch.source...), '\n', '\n')
+	}
+	ext.FrontMatter = false
+	doc := goldmark.New(goldmark.WithExtensions(latex.Extension(ext))).Parser().Parse(text.NewReader(source))
+	lr := latex.NewRenderer(cfg).(*latex.Renderer)
+	data := lr.TemplateData(doc, source)
+	data.Body = "\n" + includes.String()
+	var b bytes.Buffer
+	err = lr.ExecuteTemplate(&b, data)
+	if err != nil {
+		return err
+	}
+	verb("writing master file", master)
+	err = os.WriteFile(master, b.Bytes(), 0666)
+	if err != nil {
+		return err
+	}
+	if pdf {
+		err = buildPDF(context.Background(), master, cfg.Engine, maps)
+		if err != nil {
+			return err
+		}
+	}
+	return lost
+}
+
+// bookLinkResolver returns a link resolver for a chapter file which looks up
+// destinations such as "#heading" or "other.md#heading" in the label table.
+func bookLinkResolver(labels map[string]string, filename string) func([]byte) (string, bool) {
+	return func(destination []byte) (string, bool) {
+		dest := string(destination)
+		if strings.Contains(dest, "://") {
+			return "", false
+		}
+		if strings.HasPrefix(dest, "#") {
+			dest = filename + dest
+		} else {
+			dest = filepath.Base(dest)
+		}
+		label, ok := labels[dest]
+		return label, ok
+	}
+}
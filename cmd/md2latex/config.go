@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	latex "github.com/soypat/goldmark-latex"
+	"gopkg.in/yaml.v2"
+)
+
+// configNames are the configuration files looked up in the working directory, in order.
+var configNames = []string{"md2latex.yaml", "md2latex.yml", "md2latex.toml", "md2latex.json"}
+
+// pathFlags are the flags holding paths. Relative paths set in a
+// configuration file are relative to the directory of the file.
+var pathFlags = map[string]bool{"preamble": true, "template": true, "o": true, "outdir": true, "r": true}
+
+// fileFlags are the flags read by latexConfig, which can be overridden for
+// input files matching a pattern.
+var fileFlags = map[string]bool{
+	"class": true, "part": true, "headingoffset": true, "unhead": true, "preamble": true,
+	"fullpreamble": true, "template": true, "toc": true, "unsafe": true, "unicode": true,
+	"inlineunicode": true, "labelprefix": true, "lang": true, "engine": true, "mainfont": true,
+	"sansfont": true, "monofont": true, "mathfont": true, "cjkfont": true, "hebrewfont": true,
+	"arabicfont": true, "beamer": true, "theme": true,
+}
+
+// projectConfig is the configuration loaded from a configuration file.
+type projectConfig struct {
+	format    string // yaml, toml or json.
+	filename  string // Configuration file, empty if none.
+	dir       string // Directory of the configuration file.
+	cmdline   map[string]bool
+	overrides []override
+}
+
+// override holds flag values for input files matching a glob pattern.
+type override struct {
+	files    string // Pattern relative to the configuration file directory.
+	settings map[string]interface{}
+}
+
+var (
+	project projectConfig
+	// Macros set by the configuration file. There are no flags for them.
+	macros latex.Macros
+)
+
+// loadConfig loads the configuration file filename, or the first of configNames
+// found in the working directory if empty. Settings are named after the flags defined on fs
+// and do not replace flags set on the command line. Besides flags a configuration file may set
+// "macros", see latex.Macros, and "overrides", a list of flag values applied to input files
+// matching the "files" glob pattern:
+//
+//	class: report
+//	toc: true
+//	overrides:
+//	  - files: docs/slides/*.md
+//	    beamer: true
+func loadConfig(fs *flag.FlagSet, filename string) error {
+	cmdline := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+	return readConfig(fs, filename, cmdline)
+}
+
+// reloadConfig resets the flags not set on the command line to their defaults
+// and loads the configuration file again.
+func reloadConfig(fs *flag.FlagSet) error {
+	cmdline := project.cmdline
+	fs.VisitAll(func(f *flag.Flag) {
+		if !cmdline[f.Name] {
+			_ = f.Value.Set(f.DefValue)
+		}
+	})
+	macros = latex.Macros{}
+	return readConfig(fs, project.filename, cmdline)
+}
+
+// readConfig loads a configuration file as loadConfig does. Flags in cmdline are not set.
+func readConfig(fs *flag.FlagSet, filename string, cmdline map[string]bool) error {
+	project = projectConfig{format: "yaml", cmdline: cmdline}
+	if filename == "" {
+		for _, name := range configNames {
+			if exists(name) {
+				filename = name
+				break
+			}
+		}
+		if filename == "" {
+			return nil
+		}
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	format := strings.TrimPrefix(filepath.Ext(filename), ".")
+	if format == "yml" {
+		format = "yaml"
+	}
+	settings, err := decodeConfig(format, b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	verb("using configuration file", filename)
+	project.format = format
+	project.filename = filename
+	project.dir = filepath.Dir(filename)
+	for key, value := range settings {
+		switch key {
+		case "macros":
+			// Macro field names are matched case insensitively by encoding/json.
+			b, err = json.Marshal(value)
+			if err == nil {
+				err = json.Unmarshal(b, &macros)
+			}
+		case "overrides":
+			err = project.addOverrides(value)
+		default:
+			if project.cmdline[key] {
+				continue
+			}
+			err = project.setFlag(fs, key, value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", filename, key, err)
+		}
+	}
+	return nil
+}
+
+// configFor returns the renderer configuration for the input file filename with the
+// overrides matching it applied. Overrides are applied to the flags defined on fs
+// while the configuration is built, so configFor must not run concurrently with conversions.
+func configFor(fs *flag.FlagSet, filename string) (latex.Config, error) {
+	type saved struct{ name, value string }
+	var prev []saved
+	defer func() {
+		for i := len(prev) - 1; i >= 0; i-- {
+			_ = fs.Set(prev[i].name, prev[i].value)
+		}
+	}()
+	for _, o := range project.overrides {
+		if !project.matches(o.files, filename) {
+			continue
+		}
+		verb("applying overrides for", o.files, "to", filename)
+		for key, value := range o.settings {
+			if project.cmdline[key] {
+				continue
+			}
+			prev = append(prev, saved{key, fs.Lookup(key).Value.String()})
+			err := project.setFlag(fs, key, value)
+			if err != nil {
+				return latex.Config{}, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+	return latexConfig()
+}
+
+// writeConfig writes the values of the flags defined on fs and the macros and
+// overrides of the configuration file in the format of the loaded file, YAML if none.
+func writeConfig(w io.Writer, fs *flag.FlagSet) error {
+	settings := make(map[string]interface{})
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+		settings[f.Name] = f.Value.(flag.Getter).Get()
+	})
+	if m := lowerKeys(macros); !isZero(m) {
+		settings["macros"] = m
+	}
+	var overrides []map[string]interface{}
+	for _, o := range project.overrides {
+		m := map[string]interface{}{"files": o.files}
+		for key, value := range o.settings {
+			m[key] = value
+		}
+		overrides = append(overrides, m)
+	}
+	if overrides != nil {
+		settings["overrides"] = overrides
+	}
+	switch project.format {
+	case "toml":
+		return toml.NewEncoder(w).Encode(settings)
+	case "json":
+		b, err := json.MarshalIndent(settings, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+	}
+	b, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func decodeConfig(format string, b []byte) (map[string]interface{}, error) {
+	var settings map[string]interface{}
+	var err error
+	switch format {
+	case "yaml":
+		err = yaml.Unmarshal(b, &settings)
+	case "toml":
+		err = toml.Unmarshal(b, &settings)
+	case "json":
+		err = json.Unmarshal(b, &settings)
+	default:
+		return nil, fmt.Errorf("unknown configuration format %q, use .yaml, .toml or .json", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return normalize(settings).(map[string]interface{}), nil
+}
+
+// normalize converts the maps and lists decoded by the yaml and toml packages
+// to map[string]interface{} and []interface{}.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalize(value)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = normalize(value)
+		}
+		return m
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = normalize(v[i])
+		}
+		return list
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = normalize(v[i])
+		}
+		return list
+	}
+	return v
+}
+
+func (p *projectConfig) addOverrides(value interface{}) error {
+	list, ok := value.([]interface{})
+	if !ok {
+		return errors.New("must be a list")
+	}
+	for _, item := range list {
+		m, _ := item.(map[string]interface{})
+		pattern, _ := m["files"].(string)
+		if pattern == "" {
+			return errors.New("each override must set files to a glob pattern")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: %w", pattern, err)
+		}
+		o := override{files: pattern, settings: make(map[string]interface{})}
+		for key, value := range m {
+			if key == "files" {
+				continue
+			}
+			if !fileFlags[key] {
+				return fmt.Errorf("%s can not be overridden per file", key)
+			}
+			o.settings[key] = value
+		}
+		p.overrides = append(p.overrides, o)
+	}
+	return nil
+}
+
+// setFlag sets the flag named key to a value read from the configuration file.
+func (p *projectConfig) setFlag(fs *flag.FlagSet, key string, value interface{}) error {
+	f := fs.Lookup(key)
+	if f == nil || key == "config" || key == "print-config" {
+		return errors.New("unknown setting")
+	}
+	var s string
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return errors.New("must not be a map")
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i := range v {
+			parts[i] = fmt.Sprint(v[i])
+		}
+		s = strings.Join(parts, ",")
+	default:
+		s = fmt.Sprint(v)
+	}
+	if pathFlags[key] && s != "" && !filepath.IsAbs(s) {
+		s = filepath.Join(p.dir, s)
+	}
+	return fs.Set(key, s)
+}
+
+// matches reports whether filename matches pattern relative to the configuration file directory.
+func (p *projectConfig) matches(pattern, filename string) bool {
+	rel := filename
+	abs, err := filepath.Abs(filename)
+	if err == nil {
+		dir, err := filepath.Abs(p.dir)
+		if err == nil {
+			rel, err = filepath.Rel(dir, abs)
+		}
+		if err != nil {
+			rel = filename
+		}
+	}
+	ok, _ := path.Match(pattern, filepath.ToSlash(rel))
+	return ok
+}
+
+// lowerKeys returns v encoded as JSON and decoded with lower case object keys,
+// which is how structs without tags are written by the yaml package.
+// Fields holding only empty strings are left out.
+func lowerKeys(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var generic interface{}
+	_ = json.Unmarshal(b, &generic)
+	var lower func(v interface{}) interface{}
+	lower = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			m := make(map[string]interface{}, len(v))
+			for key, value := range v {
+				if !isZero(value) {
+					m[strings.ToLower(key)] = lower(value)
+				}
+			}
+			return m
+		case []interface{}:
+			for i := range v {
+				v[i] = lower(v[i])
+			}
+		}
+		return v
+	}
+	return lower(generic)
+}
+
+// isZero reports whether v decoded from JSON holds only empty strings.
+func isZero(v interface{}) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, value := range v {
+			if !isZero(value) {
+				return false
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if !isZero(value) {
+				return false
+			}
+		}
+	case string:
+		return v == ""
+	}
+	return true
+}
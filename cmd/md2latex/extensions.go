@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	latex "github.com/soypat/goldmark-latex"
+	"github.com/yuin/goldmark"
+	meta "github.com/yuin/goldmark-meta"
+	"github.com/yuin/goldmark/extension"
+)
+
+// extensionConfig returns the parser extensions enabled by the extension flags.
+// Config is left for the caller to set.
+func extensionConfig() (latex.ExtensionConfig, error) {
+	e := latex.ExtensionConfig{
+		Footnote:       useFootnotes,
+		DefinitionList: useDeflist,
+		Typographer:    useTypographer,
+		Math:           useMath,
+		FrontMatter:    useMeta,
+	}
+	if useGFM {
+		_ = enableExtension(&e, "gfm")
+	}
+	for _, name := range strings.Split(extensions, ",") {
+		err := enableExtension(&e, name)
+		if err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+// enableExtension enables the parser extension name in e.
+func enableExtension(e *latex.ExtensionConfig, name string) error {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "":
+	case "gfm":
+		e.Table, e.Strikethrough, e.TaskList, e.Linkify = true, true, true, true
+	case "table":
+		e.Table = true
+	case "strikethrough":
+		e.Strikethrough = true
+	case "tasklist":
+		e.TaskList = true
+	case "linkify":
+		e.Linkify = true
+	case "footnote", "footnotes":
+		e.Footnote = true
+	case "deflist", "definitionlist":
+		e.DefinitionList = true
+	case "typographer":
+		e.Typographer = true
+	case "math":
+		e.Math = true
+	case "meta", "frontmatter":
+		e.FrontMatter = true
+	default:
+		return fmt.Errorf("unknown extension %q", name)
+	}
+	return nil
+}
+
+// htmlExtensions returns goldmark's HTML rendering extensions matching e.
+// There is no HTML rendering of math, which is left as text.
+func htmlExtensions(e latex.ExtensionConfig) []goldmark.Extender {
+	var exts []goldmark.Extender
+	for _, ext := range []struct {
+		enabled bool
+		ext     goldmark.Extender
+	}{
+		{e.Table, extension.Table},
+		{e.Strikethrough, extension.Strikethrough},
+		{e.TaskList, extension.TaskList},
+		{e.Linkify, extension.Linkify},
+		{e.Footnote, extension.Footnote},
+		{e.DefinitionList, extension.DefinitionList},
+		{e.Typographer, extension.Typographer},
+		{e.FrontMatter, meta.Meta},
+	} {
+		if ext.enabled {
+			exts = append(exts, ext.ext)
+		}
+	}
+	return exts
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,13 +11,14 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"text/template"
 	"time"
 
 	latex "github.com/soypat/goldmark-latex"
 	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/renderer"
-	"github.com/yuin/goldmark/util"
+	"github.com/yuin/goldmark/text"
 )
 
 var (
@@ -24,94 +27,343 @@ var (
 	print            bool
 	unhead           bool
 	unsafe           bool
+	usePart          bool
+	beamer           bool
+	fragment         bool
+	book             bool
+	beamerTheme      string
+	documentClass    string
 	preambleFilename string
+	templateFilename string
+	toc              bool
+	fullPreamble     bool
+	engine           string
+	language         string
+	declareUnicode   bool
+	inlineUnicode    bool
+	fonts            latex.Fonts
 	outputFilename   string
 	headingOffset    int
+	check            bool
+	strict           bool
+	sourceMap        bool
+	lineMarkers      bool
+	pdf              bool
+	latexmk          string
+	watchMode        bool
+	recurseDir       string
+	outputDir        string
+	jobs             int
+	labelPrefix      string
+	configFilename   string
+	printConfig      bool
+	useGFM           bool
+	useFootnotes     bool
+	useDeflist       bool
+	useTypographer   bool
+	useMath          bool
+	useMeta          bool
+	extensions       string
 )
 
 func main() {
-	flag.BoolVar(&verbose, "v", false, "Verbose output.")
-	flag.BoolVar(&usehtml, "html", false, "Output html")
-	flag.BoolVar(&print, "p", false, "Output to stdout")
-	flag.BoolVar(&unsafe, "unsafe", false, "Render unsafe segments of document such as links or verbatim.")
-	flag.BoolVar(&unhead, "unhead", false, "No section numbering")
-	flag.StringVar(&outputFilename, "o", "", "Output filename. By default just adds .tex to input filename.")
-	flag.StringVar(&preambleFilename, "preamble", "", "Preamble filename. If not set uses a default preamble.")
-	flag.IntVar(&headingOffset, "headingoffset", 0, "Section heading offset. Can be negative. Results are clipped between 1 and 6.")
+	defineFlags(flag.CommandLine)
 	flag.Parse()
+	err := loadConfig(flag.CommandLine, configFilename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		err = writeConfig(os.Stdout, flag.CommandLine)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	args := flag.Args()
-	err := run(args)
+	if len(args) > 0 && args[0] == "serve" {
+		err = runServe(args[1:])
+	} else if watchMode {
+		err = watch(args, nil)
+	} else {
+		err = run(args)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+// defineFlags defines the command line flags on fs. Flags can also be set
+// in the configuration file by name, see loadConfig.
+func defineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&verbose, "v", false, "Verbose output.")
+	fs.BoolVar(&usehtml, "html", false, "Output html")
+	fs.BoolVar(&print, "p", false, "Output to stdout")
+	fs.BoolVar(&unsafe, "unsafe", false, "Render unsafe segments of document such as links or verbatim.")
+	fs.BoolVar(&unhead, "unhead", false, "No section numbering")
+	fs.BoolVar(&usePart, "part", false, "Render top level headings as \\part.")
+	fs.StringVar(&documentClass, "class", "", "Document class, i.e: article, report, book, memoir, scrartcl, scrreprt, scrbook.")
+	fs.BoolVar(&beamer, "beamer", false, "Output a beamer slide deck.")
+	fs.BoolVar(&fragment, "fragment", false, "Output only the document body for use with \\input. Use with -v to list required packages.")
+	fs.BoolVar(&book, "book", false, "Render each input file as a chapter and write a master file that includes them. Output filename defaults to book.tex.")
+	fs.StringVar(&beamerTheme, "theme", "", "Beamer theme, i.e: Madrid. Only used with -beamer.")
+	fs.StringVar(&outputFilename, "o", "", "Output filename, - for stdout. By default just adds .tex to input filename. An input filename of - reads from stdin.")
+	fs.StringVar(&preambleFilename, "preamble", "", "Preamble filename. If not set uses a default preamble.")
+	fs.StringVar(&templateFilename, "template", "", "Go text/template document template filename. The template must write {{.Body}}.")
+	fs.BoolVar(&fullPreamble, "fullpreamble", false, "Load all packages of the default preamble, not only those used by the document.")
+	fs.StringVar(&engine, "engine", "pdflatex", "TeX engine the output is compiled with: pdflatex, xelatex or lualatex.")
+	fs.StringVar(&language, "lang", "", "Document language, i.e: es, de, fr. Overridden by the lang metadata key.")
+	fs.StringVar(&fonts.Main, "mainfont", "", "Main font for xelatex and lualatex engines.")
+	fs.StringVar(&fonts.Sans, "sansfont", "", "Sans serif font for xelatex and lualatex engines.")
+	fs.StringVar(&fonts.Mono, "monofont", "", "Monospace font for xelatex and lualatex engines.")
+	fs.StringVar(&fonts.Math, "mathfont", "", "Math font for xelatex and lualatex engines.")
+	fs.StringVar(&fonts.CJK, "cjkfont", "", "Font for Chinese, Japanese and Korean text. A CJKutf8 family such as gbsn with pdflatex.")
+	fs.StringVar(&fonts.Hebrew, "hebrewfont", "", "Font for Hebrew text for xelatex and lualatex engines.")
+	fs.StringVar(&fonts.Arabic, "arabicfont", "", "Font for Arabic text for xelatex and lualatex engines.")
+	fs.BoolVar(&declareUnicode, "unicode", false, "Declare non-ASCII characters in the preamble using a built-in transliteration table. pdflatex only.")
+	fs.BoolVar(&inlineUnicode, "inlineunicode", false, "Replace non-ASCII characters inline using a built-in transliteration table. pdflatex only.")
+	fs.BoolVar(&toc, "toc", false, "Add a table of contents.")
+	fs.BoolVar(&check, "check", false, "Render without writing output and list the markdown constructs dropped or degraded in LaTeX. Exits with an error if any are found.")
+	fs.BoolVar(&strict, "strict", false, "Exit with an error after writing output if any markdown constructs were dropped or degraded.")
+	fs.BoolVar(&sourceMap, "sourcemap", false, "Write a JSON source map from output lines to markdown lines next to the output, i.e: notes.tex.map.")
+	fs.BoolVar(&lineMarkers, "linemarkers", false, "Write a %!md:LINE:COLUMN comment with the markdown position before each block.")
+	fs.BoolVar(&pdf, "pdf", false, "Typeset the output with the engine set by -engine and write a PDF next to it. Errors in the TeX log are reported at markdown lines.")
+	fs.StringVar(&latexmk, "latexmk", "", "Path to latexmk, i.e: latexmk. If set -pdf runs latexmk instead of the engine directly.")
+	fs.BoolVar(&watchMode, "watch", false, "Render again whenever the input, preamble, template or configuration files change, including files added under -r. Use with -pdf to also typeset the PDF.")
+	fs.StringVar(&recurseDir, "r", "", "Convert every .md file in the directory tree. Output mirrors the tree in -outdir.")
+	fs.StringVar(&outputDir, "outdir", "", "Output directory when converting a directory tree or several files. By default output is written next to each input.")
+	fs.IntVar(&jobs, "j", runtime.NumCPU(), "Number of files converted in parallel with -r or several input files.")
+	fs.StringVar(&labelPrefix, "labelprefix", "", "Prefix added to heading labels.")
+	fs.StringVar(&configFilename, "config", "", "Configuration file. Defaults to md2latex.yaml, md2latex.toml or md2latex.json in the working directory if present.")
+	fs.BoolVar(&printConfig, "print-config", false, "Print the configuration merged from the configuration file and flags and exit.")
+	fs.BoolVar(&useGFM, "gfm", false, "Parse GitHub flavored markdown: tables, strikethrough, task lists and links from URLs in text.")
+	fs.BoolVar(&useFootnotes, "footnotes", false, "Parse footnotes such as [^1], rendered as \\footnote.")
+	fs.BoolVar(&useDeflist, "deflist", false, "Parse definition lists, rendered as description environments.")
+	fs.BoolVar(&useTypographer, "typographer", false, "Replace quotes, dashes and ellipses with their typographic LaTeX equivalents.")
+	fs.BoolVar(&useMath, "math", false, "Parse TeX math between $ and $$ delimiters.")
+	fs.BoolVar(&useMeta, "meta", false, "Parse YAML front matter. The title, author, date, toc and lang keys are used by the document template.")
+	fs.StringVar(&extensions, "ext", "", "Comma separated parser extensions: gfm, table, strikethrough, tasklist, linkify, footnote, deflist, typographer, math and meta.")
+	fs.IntVar(&headingOffset, "headingoffset", 0, "Section heading offset. Can be negative. Results are clipped between the top level of the document class and \\subparagraph.")
+}
+
 func run(args []string) error {
-	if len(args) == 0 {
+	if len(args) == 0 && recurseDir == "" {
 		return errors.New("missing filename argument")
 	}
 	verb("beginning verbose run")
+	if pdf && (print || usehtml || fragment) {
+		return errors.New("-pdf can not be used with -p, -html or -fragment")
+	}
+	if book {
+		return runBook(args)
+	}
+	if recurseDir != "" || len(args) > 1 {
+		return runBatch(args)
+	}
 	filename := args[0]
-	input, err := readFile(filename)
+	cfg, err := configFor(flag.CommandLine, filename)
 	if err != nil {
 		return err
 	}
+	outname := outputFilename
+	if print || filename == "-" && outname == "" {
+		outname = "-"
+	} else if outname == "" {
+		outname = texFilename(filename)
+	}
+	return convert(cfg, filename, outname)
+}
+
+// texFilename returns the default output filename for the markdown file filename.
+func texFilename(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".tex"
+}
+
+// convert renders the markdown file filename with cfg and writes the output to outname.
+// A filename of "-" reads from stdin and an outname of "-" writes to stdout.
+func convert(cfg latex.Config, filename, outname string) error {
+	var input []byte
+	var err error
+	if filename == "-" {
+		filename = "<stdin>"
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = readFile(filename)
+	}
+	if err != nil {
+		return err
+	}
+	ext, err := extensionConfig()
 	if err != nil {
 		return err
 	}
-	output, err := renderGoldmark(input)
+	ext.Config = cfg
+	output, diags, err := renderGoldmark(ext, input)
 	if err != nil {
 		return err
 	}
-	if print {
+	if !usehtml && cfg.DeclareUnicode != nil && !cfg.Engine.Unicode() {
+		for _, c := range latex.UnmappedRunes(input) {
+			log.Printf("%s: no LaTeX replacement for %q (%U)", filename, c, c)
+		}
+	}
+	lost := reportDiagnostics(filename, diags)
+	if check {
+		return lost
+	}
+	if !strict {
+		lost = nil
+	}
+	var sm latex.SourceMap
+	if (sourceMap || pdf) && !usehtml {
+		output, sm = latex.ExtractSourceMap(output, lineMarkers)
+		sm.Source = filename
+	}
+	if outname == "-" {
+		if pdf {
+			return errors.New("-pdf requires an output file")
+		}
 		fmt.Println(string(output))
-		return nil
+		return lost
+	}
+	err = os.WriteFile(outname, output, 0666)
+	if err != nil {
+		return err
+	}
+	if sourceMap && !usehtml {
+		err = writeSourceMap(outname, sm)
+		if err != nil {
+			return err
+		}
 	}
-	// Generate output file.
-	ext := filepath.Ext(filename)
-	if ext == "" && outputFilename == "" {
-		outputFilename = filename + ".tex"
-	} else if outputFilename == "" {
-		outputFilename = strings.TrimSuffix(filename, ext) + ".tex"
+	if pdf {
+		err = buildPDF(context.Background(), outname, cfg.Engine, map[string]latex.SourceMap{filepath.Base(outname): sm})
+		if err != nil {
+			return err
+		}
 	}
-	outfp, err := os.Create(outputFilename)
+	return lost
+}
+
+// writeSourceMap writes sm as JSON next to the LaTeX file texname.
+func writeSourceMap(texname string, sm latex.SourceMap) error {
+	b, err := json.MarshalIndent(sm, "", "\t")
 	if err != nil {
 		return err
 	}
-	defer outfp.Close()
-	_, err = io.Copy(outfp, bytes.NewBuffer(output))
-	return err
+	verb("writing source map", texname+".map")
+	return os.WriteFile(texname+".map", b, 0666)
 }
 
-func renderGoldmark(input []byte) ([]byte, error) {
-	var preamble []byte
-	if preambleFilename != "" {
-		b, err := readFile(preambleFilename)
-		if err != nil {
-			return nil, err
+// reportDiagnostics prints diagnostics to stderr as "file.md:LINE:COL: warning: message"
+// and returns an error if any of them is a warning of content dropped or degraded.
+func reportDiagnostics(filename string, diags []latex.Diagnostic) error {
+	warnings := 0
+	for _, d := range diags {
+		if d.Severity == latex.SeverityWarning {
+			warnings++
 		}
-		verb("replacing default preamble with", preambleFilename, "of length", len(b))
-		preamble = b
+		if d.Line > 0 {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", filename, d)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", filename, d.Severity, d.Message)
+		}
+	}
+	if warnings > 0 {
+		return fmt.Errorf("%s: %d markdown constructs dropped or degraded", filename, warnings)
 	}
-	var rd renderer.Renderer
+	return nil
+}
+
+// renderGoldmark renders input with the extensions and renderer configuration of ext
+// and returns the output and the diagnostics reported by the LaTeX renderer.
+func renderGoldmark(ext latex.ExtensionConfig, input []byte) ([]byte, []latex.Diagnostic, error) {
+	cfg := ext.Config
+	var md goldmark.Markdown
+	var lr *latex.Renderer
+	var diags latex.DiagnosticCollector
 	if usehtml {
 		verb("using html renderer")
-		rd = goldmark.DefaultRenderer()
+		md = goldmark.New(goldmark.WithExtensions(htmlExtensions(ext)...))
 	} else {
-		rd = renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(latex.NewRenderer(latex.Config{
-			NoHeadingNumbering: unhead,
-			Unsafe:             unsafe,
-			Preamble:           preamble,
-			HeadingLevelOffset: headingOffset,
-		}), 1000)))
-	}
-	md := goldmark.New(goldmark.WithRenderer(rd))
+		cfg.OnDiagnostic = diags.Report
+		ext.Config = cfg
+		lr = latex.NewRenderer(cfg).(*latex.Renderer)
+		md = goldmark.New(goldmark.WithExtensions(latex.Extension(ext)))
+	}
 	var b bytes.Buffer
 	verb("start rendering using goldmark")
 	start := time.Now()
-	err := md.Convert(input, &b)
+	doc := md.Parser().Parse(text.NewReader(input))
+	err := md.Renderer().Render(&b, input, doc)
 	verb("finished rendering in", time.Since(start))
-	return b.Bytes(), err
+	if fragment && lr != nil {
+		for _, pkg := range lr.RequiredPackages(doc, input) {
+			verb("fragment requires", pkg.String())
+		}
+	}
+	return b.Bytes(), diags.Diagnostics(), err
+}
+
+// latexConfig returns the renderer configuration set by command line flags.
+func latexConfig() (latex.Config, error) {
+	var preamble []byte
+	if preambleFilename != "" {
+		b, err := readFile(preambleFilename)
+		if err != nil {
+			return latex.Config{}, err
+		}
+		verb("replacing default preamble with", preambleFilename, "of length", len(b))
+		preamble = b
+	}
+	var tmpl *template.Template
+	if templateFilename != "" {
+		b, err := readFile(templateFilename)
+		if err != nil {
+			return latex.Config{}, err
+		}
+		tmpl, err = latex.ParseTemplate(string(b))
+		if err != nil {
+			return latex.Config{}, err
+		}
+		verb("using template", templateFilename)
+	}
+	switch latex.Engine(engine) {
+	case latex.PDFLaTeX, latex.XeLaTeX, latex.LuaLaTeX:
+	default:
+		return latex.Config{}, fmt.Errorf("unknown engine %q", engine)
+	}
+	mode := latex.Document
+	if beamer {
+		verb("using beamer mode")
+		mode = latex.Beamer
+	}
+	var declare func(rune) (string, bool)
+	if declareUnicode || inlineUnicode {
+		declare = latex.UnicodeToLaTeX
+	}
+	return latex.Config{
+		DeclareUnicode:     declare,
+		InlineUnicode:      inlineUnicode,
+		LineMarkers:        lineMarkers || sourceMap || pdf,
+		NoHeadingNumbering: unhead,
+		Unsafe:             unsafe,
+		Preamble:           preamble,
+		Template:           tmpl,
+		TOC:                toc,
+		FullPreamble:       fullPreamble,
+		Engine:             latex.Engine(engine),
+		Language:           language,
+		Fonts:              fonts,
+		HeadingLevelOffset: headingOffset,
+		DocumentClass:      latex.DocumentClass(documentClass),
+		UsePart:            usePart,
+		Mode:               mode,
+		BeamerTheme:        beamerTheme,
+		Fragment:           fragment,
+		LabelPrefix:        labelPrefix,
+		Macros:             macros,
+	}, nil
 }
 
 // Opens, reads and closes file and returns contents.
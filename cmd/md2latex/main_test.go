@@ -0,0 +1,626 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	latex "github.com/soypat/goldmark-latex"
+)
+
+// stubEngine is a TeX engine stand in. It fails with an undefined control sequence
+// error at the first line containing \undefinedmacro and otherwise writes a PDF.
+// The command line is written to $STUB_ARGS.
+const stubEngine = `#!/bin/sh
+echo "$@" > "$STUB_ARGS"
+for arg; do tex=$arg; done
+name=$(basename "$tex" .tex)
+line=$(grep -n undefinedmacro "$tex" | head -n 1 | cut -d: -f1)
+echo aux > "$name.aux"
+if [ -n "$line" ]; then
+	printf '(%s\n%s:%s: Undefined control sequence.\nl.%s Call\n' "$tex" "$tex" "$line" "$line" > "$name.log"
+	exit 1
+fi
+printf '(%s\n[1] )\nOutput written on %s.pdf (1 page).\n' "$tex" "$name" > "$name.log"
+printf '%%PDF-1.5 stub\n' > "$name.pdf"
+`
+
+// installStubEngine puts stubEngine on PATH as pdflatex and latexmk and returns
+// the temporary directory the engine runs in and the file its arguments are written to.
+func installStubEngine(t *testing.T) (tmp, argsFile string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stub engine is a shell script")
+	}
+	bin := t.TempDir()
+	for _, name := range []string{"pdflatex", "latexmk"} {
+		err := os.WriteFile(filepath.Join(bin, name), []byte(stubEngine), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(filepath.ListSeparator)+os.Getenv("PATH"))
+	tmp = t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	argsFile = filepath.Join(t.TempDir(), "args")
+	t.Setenv("STUB_ARGS", argsFile)
+	return tmp, argsFile
+}
+
+// useCommandLine defines the flags on a new flag.CommandLine for the duration of the test.
+func useCommandLine(t *testing.T) {
+	commandLine := flag.CommandLine
+	flag.CommandLine = flag.NewFlagSet("md2latex", flag.ContinueOnError)
+	defineFlags(flag.CommandLine)
+	t.Cleanup(func() {
+		flag.CommandLine = flag.NewFlagSet("md2latex", flag.ContinueOnError)
+		defineFlags(flag.CommandLine) // Reset flag variables.
+		flag.CommandLine, engine, jobs, project, macros = commandLine, "", 0, projectConfig{}, latex.Macros{}
+	})
+}
+
+func TestPDF(t *testing.T) {
+	tmp, argsFile := installStubEngine(t)
+	t.Cleanup(func() { pdf, engine, latexmk, outputFilename = false, "", "", "" })
+
+	for _, test := range []struct {
+		engine, latexmk string
+		markdown        string
+		wantErr         string
+		wantArgs        string
+	}{
+		{engine: "pdflatex", markdown: "# Notes\n\nSome text.\n", wantArgs: "-interaction=nonstopmode -halt-on-error"},
+		{engine: "pdflatex", markdown: "# Notes\n\nSome text.\n\nCall \\undefinedmacro here.\n", wantErr: "notes.md:5:1: error: Undefined control sequence."},
+		{engine: "xelatex", latexmk: "latexmk", markdown: "# Notes\n", wantArgs: "-xelatex -interaction=nonstopmode"},
+	} {
+		dir := t.TempDir()
+		mdname := filepath.Join(dir, "notes.md")
+		err := os.WriteFile(mdname, []byte(test.markdown), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pdf, engine, latexmk, outputFilename = true, test.engine, test.latexmk, ""
+		err = run([]string{mdname})
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		} else if !exists(filepath.Join(dir, "notes.pdf")) {
+			t.Error("PDF not written")
+		}
+		args, err := os.ReadFile(argsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(args), test.wantArgs) {
+			t.Errorf("engine run with %q, want %q", args, test.wantArgs)
+		}
+		if exists(filepath.Join(dir, "notes.aux")) {
+			t.Error("auxiliary files written to output directory")
+		}
+		if left, _ := os.ReadDir(tmp); len(left) > 0 {
+			t.Errorf("temporary directory not removed: %v", left)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	mdname := filepath.Join(dir, "notes.md")
+	texname := filepath.Join(dir, "notes.tex")
+	tmplname := filepath.Join(dir, "template.tex")
+	watchInterval, watchDebounce = 10*time.Millisecond, 20*time.Millisecond
+	engine, outputFilename, templateFilename = "pdflatex", "", tmplname
+	t.Cleanup(func() {
+		watchInterval, watchDebounce = 500*time.Millisecond, 200*time.Millisecond
+		engine, outputFilename, templateFilename = "", "", ""
+	})
+	write := func(name, content string) {
+		t.Helper()
+		err := os.WriteFile(name, []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			b, _ := os.ReadFile(texname)
+			if strings.Contains(string(b), want) {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		b, _ := os.ReadFile(texname)
+		t.Fatalf("output %q does not contain %q", b, want)
+	}
+	write(mdname, "First draft.\n")
+	write(tmplname, "{{.Body}}")
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- watch([]string{mdname}, stop) }()
+	waitFor("First draft.")
+
+	// Modification times may have a coarse resolution, change sizes too.
+	write(mdname, "Second draft, longer.\n")
+	waitFor("Second draft, longer.")
+
+	// A render error is reported and watching continues.
+	write(tmplname, "{{.Body")
+	time.Sleep(100 * time.Millisecond)
+	write(tmplname, "% fixed template\n{{.Body}}")
+	waitFor("% fixed template")
+	write(mdname, "Third and last draft.\n")
+	waitFor("Third and last draft.")
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchDir(t *testing.T) {
+	dir := t.TempDir()
+	docs := filepath.Join(dir, "docs")
+	configName := filepath.Join(dir, "md2latex.yaml")
+	write := func(name, content string) {
+		t.Helper()
+		err := os.MkdirAll(filepath.Dir(name), 0777)
+		if err == nil {
+			err = os.WriteFile(name, []byte(content), 0666)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(name, want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			b, _ := os.ReadFile(name)
+			if strings.Contains(string(b), want) {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		b, _ := os.ReadFile(name)
+		t.Fatalf("%s %q does not contain %q", name, b, want)
+	}
+	useCommandLine(t)
+	watchInterval, watchDebounce = 10*time.Millisecond, 20*time.Millisecond
+	t.Cleanup(func() { watchInterval, watchDebounce = 500*time.Millisecond, 200*time.Millisecond })
+	write(filepath.Join(docs, "a.md"), "# A\n")
+	write(configName, "class: report\nr: docs\n")
+	err := loadConfig(flag.CommandLine, configName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- watch(nil, stop) }()
+	waitFor(filepath.Join(docs, "a.tex"), "\\documentclass{report}")
+
+	// Files added to the tree are converted.
+	write(filepath.Join(docs, "sub", "b.md"), "# B\n")
+	waitFor(filepath.Join(docs, "sub", "b.tex"), "\\chapter{B}")
+
+	// The configuration file is loaded again when it changes.
+	write(configName, "class: article\nr: docs\n")
+	waitFor(filepath.Join(docs, "a.tex"), "\\documentclass{article}")
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"docs/intro.md":          "# Intro\n",
+		"docs/guide/setup.md":    "# Setup\n",
+		"docs/guide/raw.md":      "Some <b>raw</b> HTML.\n",
+		"docs/guide/notes.txt":   "Not markdown.\n",
+		"docs/guide/deep/ref.md": "# Reference\n",
+	} {
+		name = filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(name), 0777)
+		if err == nil {
+			err = os.WriteFile(name, []byte(content), 0666)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	outdir := filepath.Join(dir, "build")
+	engine, recurseDir, outputDir, jobs, strict = "pdflatex", filepath.Join(dir, "docs"), outdir, 2, true
+	t.Cleanup(func() { engine, recurseDir, outputDir, jobs, strict = "", "", "", 0, false })
+	err := run(nil)
+	if err == nil || err.Error() != "1 of 4 files failed" {
+		t.Errorf("got error %v, want 1 of 4 files failed", err)
+	}
+	for _, name := range []string{"intro.tex", "guide/setup.tex", "guide/raw.tex", "guide/deep/ref.tex"} {
+		if !exists(filepath.Join(outdir, name)) {
+			t.Errorf("%s not written", name)
+		}
+	}
+	if exists(filepath.Join(outdir, "guide/notes.tex")) {
+		t.Error("non markdown file converted")
+	}
+
+	strict = false
+	if err := run(nil); err != nil {
+		t.Errorf("got error %v converting without -strict", err)
+	}
+
+	// Input files are written to -outdir by base name, which must not collide.
+	recurseDir = ""
+	err = run([]string{filepath.Join(dir, "docs/intro.md"), filepath.Join(dir, "docs/guide/deep/ref.md"), filepath.Join(dir, "docs/guide/raw.md")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "docs/guide/intro.md")
+	if err := os.WriteFile(other, []byte("# Other intro\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	err = run([]string{filepath.Join(dir, "docs/intro.md"), other})
+	if err == nil || !strings.Contains(err.Error(), "are both converted to "+filepath.Join(outdir, "intro.tex")) {
+		t.Errorf("got error %v, want both converted to intro.tex", err)
+	}
+}
+
+func TestBook(t *testing.T) {
+	dir := t.TempDir()
+	ch1 := filepath.Join(dir, "intro.md")
+	ch2 := filepath.Join(dir, "setup.md")
+	for name, content := range map[string]string{
+		ch1: "# Introduction\n\nSee the [setup](setup.md#install).\n",
+		ch2: "Some text about the café, 中文.\n\n## Install\n",
+	} {
+		err := os.WriteFile(name, []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	useCommandLine(t)
+	project = projectConfig{dir: dir, cmdline: map[string]bool{}, overrides: []override{
+		{files: "setup.md", settings: map[string]interface{}{"unhead": true}},
+	}}
+
+	master := filepath.Join(dir, "build", "book.tex")
+	for _, test := range []struct {
+		class    string
+		fallback string
+		install  string
+	}{
+		{class: "", fallback: "\\chapter{setup}\\label{setup}", install: "\\section*{Install}"},
+		{class: "article", fallback: "\\section{setup}\\label{setup}", install: "\\subsection*{Install}"},
+	} {
+		book, documentClass, outputFilename, declareUnicode = true, test.class, master, true
+		err := run([]string{ch1, ch2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, wants := range map[string][]string{
+			"book.tex":  {"\\include{intro}", "\\include{setup}", "\\DeclareUnicodeCharacter{00e9}", "\\usepackage{CJKutf8}", "\\usepackage{hyperref}"},
+			"intro.tex": {"\\label{intro:introduction}", "\\hyperref[setup:install]"},
+			// Per file overrides apply to chapters.
+			"setup.tex": {test.fallback, test.install},
+		} {
+			output, err := os.ReadFile(filepath.Join(dir, "build", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range wants {
+				if !strings.Contains(string(output), want) {
+					t.Errorf("class %q: missing %q in %s:\n%s", test.class, want, name, output)
+				}
+			}
+		}
+	}
+	// Chapters with the same name would overwrite each other.
+	other := filepath.Join(dir, "other", "intro.md")
+	err := os.MkdirAll(filepath.Dir(other), 0777)
+	if err == nil {
+		err = os.WriteFile(other, []byte("# Other introduction\n"), 0666)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = run([]string{ch1, ch2, other})
+	if want := "are both converted to " + filepath.Join(dir, "build", "intro.tex"); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestStdio(t *testing.T) {
+	dir := t.TempDir()
+	stdin, err := os.Create(filepath.Join(dir, "stdin"))
+	if err == nil {
+		_, err = stdin.WriteString("# From stdin\n")
+	}
+	if err == nil {
+		_, err = stdin.Seek(0, 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	savedIn, savedOut := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout, engine = stdin, stdout, "pdflatex"
+	t.Cleanup(func() { os.Stdin, os.Stdout, engine = savedIn, savedOut, "" })
+	err = run([]string{"-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), `\section{From stdin}`) {
+		t.Errorf("stdout does not contain rendered stdin: %q", output)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	mdname := filepath.Join(dir, "notes.md")
+	err := os.WriteFile(mdname, []byte("# Notes\n\nSome <b>raw</b> HTML.\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	savedErr := os.Stderr
+	os.Stderr, engine, check = stderr, "pdflatex", true
+	t.Cleanup(func() { os.Stderr, engine, check = savedErr, "", false })
+	err = run([]string{mdname})
+	if err == nil || !strings.Contains(err.Error(), "2 markdown constructs dropped or degraded") {
+		t.Errorf("got error %v, want constructs dropped or degraded", err)
+	}
+	if exists(filepath.Join(dir, "notes.tex")) {
+		t.Error("output written with -check")
+	}
+	output, err := os.ReadFile(stderr.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mdname + ":3:6: warning: inline HTML skipped\n"; !strings.Contains(string(output), want) {
+		t.Errorf("got diagnostics\n%s\nwant %q", output, want)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	const config = `class: report
+toc: true
+preamble: tex/preamble.tex
+headingoffset: 1
+macros:
+  thematicbreak:
+    open: \hrule
+  heading:
+    - open: \chapter*{
+      close: "}"
+overrides:
+  - files: slides/*.md
+    beamer: true
+    theme: Madrid
+`
+	configName := filepath.Join(dir, "md2latex.yaml")
+	err := os.WriteFile(configName, []byte(config), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFlags := func(args ...string) *flag.FlagSet {
+		fs := flag.NewFlagSet("md2latex", flag.ContinueOnError)
+		defineFlags(fs)
+		err := fs.Parse(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	}
+	t.Cleanup(func() {
+		newFlags()
+		engine, jobs, macros, project = "", 0, latex.Macros{}, projectConfig{}
+	})
+
+	fs := newFlags("-class", "book")
+	err = loadConfig(fs, configName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if documentClass != "book" {
+		t.Errorf("command line flag overridden by configuration file, got class %q", documentClass)
+	}
+	if !toc || headingOffset != 1 || preambleFilename != filepath.Join(dir, "tex/preamble.tex") {
+		t.Errorf("configuration not applied: toc=%v headingoffset=%d preamble=%q", toc, headingOffset, preambleFilename)
+	}
+	if macros.ThematicBreak.Open != `\hrule` || macros.Heading[0].Close != "}" {
+		t.Errorf("macros not applied: %+v", macros)
+	}
+
+	preambleFilename = "" // Not written to disk.
+	cfg, err := configFor(fs, filepath.Join(dir, "slides", "deck.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Mode != latex.Beamer || cfg.BeamerTheme != "Madrid" || cfg.DocumentClass != "book" {
+		t.Errorf("override not applied: mode=%v theme=%q class=%q", cfg.Mode, cfg.BeamerTheme, cfg.DocumentClass)
+	}
+	if beamer || beamerTheme != "" {
+		t.Error("override not restored")
+	}
+	cfg, err = configFor(fs, filepath.Join(dir, "notes.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Mode != latex.Document {
+		t.Error("override applied to file not matching pattern")
+	}
+
+	// The printed configuration loads back to the same configuration in every format.
+	var want strings.Builder
+	err = writeConfig(&want, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"yaml", "toml", "json"} {
+		project.format = format
+		var printed strings.Builder
+		err = writeConfig(&printed, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(dir, "printed."+format)
+		err = os.WriteFile(name, []byte(printed.String()), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := project
+		macros = latex.Macros{}
+		fs := newFlags()
+		err = loadConfig(fs, name)
+		if err != nil {
+			t.Fatalf("loading printed %s configuration: %v\n%s", format, err, printed.String())
+		}
+		project.format = "yaml"
+		var got strings.Builder
+		err = writeConfig(&got, fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s configuration does not load back, got\n%s\nwant\n%s", format, got.String(), want.String())
+		}
+		project = saved
+	}
+}
+
+func TestExtensionFlags(t *testing.T) {
+	dir := t.TempDir()
+	mdname := filepath.Join(dir, "notes.md")
+	const markdown = "| a | b |\n|---|---|\n| ~~1~~ | 2 |\n\nSee[^1] \"this\" and $x^2$.\n\n[^1]: A note.\n"
+	err := os.WriteFile(mdname, []byte(markdown), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, useGFM, useTypographer, extensions = "pdflatex", true, true, "footnote, math"
+	t.Cleanup(func() { engine, useGFM, useTypographer, extensions = "", false, false, "" })
+	err = run([]string{mdname})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := os.ReadFile(filepath.Join(dir, "notes.tex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`\begin{tabular}`, `\sout{1}`, "See\\footnote{A note.} ``this'' and $x^2$."} {
+		if !strings.Contains(string(output), want) {
+			t.Errorf("missing %q in output:\n%s", want, output)
+		}
+	}
+
+	extensions = "tables"
+	err = run([]string{mdname})
+	if err == nil || !strings.Contains(err.Error(), `unknown extension "tables"`) {
+		t.Errorf("got error %v, want unknown extension", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	tmp, argsFile := installStubEngine(t)
+	engine, unsafe = "pdflatex", true
+	t.Cleanup(func() { engine, unsafe = "", false })
+	s, err := newServer(1024, 5*time.Second, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	for _, test := range []struct {
+		method, path, contentType, body string
+		wantStatus                      int
+		want, notWant                   string
+	}{
+		{method: "POST", path: "/convert?class=report&toc=true", body: "# Intro\n\nText.\n", wantStatus: http.StatusOK, want: "\\documentclass{report}"},
+		{method: "POST", path: "/convert", contentType: "application/json", body: `{"markdown": "| a |\n|---|\n| 1 |\n", "fragment": true, "ext": ["table"]}`, wantStatus: http.StatusOK, want: "\\begin{tabular}", notWant: "\\documentclass"},
+		// Unsafe is forced off even though the -unsafe flag is set.
+		{method: "POST", path: "/convert?math=true", body: "$\\input{/etc/passwd}$\n", wantStatus: http.StatusOK, notWant: "passwd"},
+		{method: "POST", path: "/convert?unsafe=true", body: "text", wantStatus: http.StatusBadRequest, want: "option unsafe: unknown option"},
+		{method: "POST", path: "/convert?preamble=/etc/passwd", body: "text", wantStatus: http.StatusBadRequest},
+		{method: "POST", path: "/convert?toc=maybe", body: "text", wantStatus: http.StatusBadRequest},
+		// Values written to the preamble can not inject LaTeX.
+		{method: "POST", path: "/convert?class=article%7D%5Cinput%7B/etc/passwd%7D", body: "text", wantStatus: http.StatusBadRequest, want: "unknown document class"},
+		{method: "POST", path: "/convert", contentType: "application/json", body: `{"markdown": "text", "lang": "es]{babel}\\input{/etc/passwd}%"}`, wantStatus: http.StatusBadRequest, want: "unknown language"},
+		{method: "POST", path: "/convert?theme=Madrid%7D%5Cinput%7B/etc/passwd%7D", body: "text", wantStatus: http.StatusBadRequest},
+		{method: "POST", path: "/convert?engine=xelatex&mainfont=Latin%7D%5Cinput%7B/etc/passwd%7D", body: "text", wantStatus: http.StatusBadRequest},
+		{method: "POST", path: "/convert?engine=xelatex&lang=pt-BR&mainfont=TeX+Gyre+Pagella", body: "text", wantStatus: http.StatusOK, want: "\\setmainfont{TeX Gyre Pagella}"},
+		{method: "POST", path: "/convert", contentType: "application/json", body: `{"text": "no markdown"}`, wantStatus: http.StatusBadRequest},
+		{method: "POST", path: "/convert", body: strings.Repeat("a", 2048), wantStatus: http.StatusRequestEntityTooLarge},
+		{method: "GET", path: "/convert", wantStatus: http.StatusMethodNotAllowed},
+		{method: "POST", path: "/pdf", body: "# Notes\n", wantStatus: http.StatusOK, want: "%PDF-1.5 stub"},
+		{method: "POST", path: "/pdf", body: "# Notes\n\nIntro.\n\nCall \\undefinedmacro.\n", wantStatus: http.StatusUnprocessableEntity, want: "document.md:5:1: error: Undefined control sequence."},
+	} {
+		req, err := http.NewRequest(test.method, ts.URL+test.path, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.wantStatus {
+			t.Errorf("%s %s: got status %d, want %d: %s", test.method, test.path, resp.StatusCode, test.wantStatus, body)
+		}
+		if !strings.Contains(string(body), test.want) || test.notWant != "" && strings.Contains(string(body), test.notWant) {
+			t.Errorf("%s %s: got body\n%s\nwant %q and not %q", test.method, test.path, body, test.want, test.notWant)
+		}
+	}
+	if args, _ := os.ReadFile(argsFile); !strings.Contains(string(args), "-no-shell-escape") {
+		t.Errorf("engine run with %q, want -no-shell-escape", args)
+	}
+	if left, _ := os.ReadDir(tmp); len(left) > 0 {
+		t.Errorf("temporary files left: %v", left)
+	}
+
+	// PDF requests beyond the limit are refused.
+	s.pdfSlots <- struct{}{}
+	resp, err := http.Post(ts.URL+"/pdf", "text/markdown", strings.NewReader("# Notes\n"))
+	<-s.pdfSlots
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("POST /pdf with no free slot: got status %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
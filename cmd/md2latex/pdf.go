@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	latex "github.com/soypat/goldmark-latex"
+	"github.com/soypat/goldmark-latex/latexlog"
+)
+
+// maxPasses limits the number of times the engine is run to resolve references.
+const maxPasses = 3
+
+// buildPDF typesets the LaTeX file texname with engine and writes the PDF next to it. The engine
+// runs in a temporary directory in batch mode so auxiliary files do not clutter
+// the output directory. maps holds the source maps of texname and any file it includes,
+// keyed by base name, and is used to report log entries at markdown positions.
+// The engine is killed if ctx is done before it finishes. engineArgs are passed to the engine,
+// or to latexmk, before the default arguments.
+func buildPDF(ctx context.Context, texname string, engine latex.Engine, maps map[string]latex.SourceMap, engineArgs ...string) error {
+	abs, err := filepath.Abs(texname)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp("", "md2latex-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	jobname := strings.TrimSuffix(filepath.Base(abs), filepath.Ext(abs))
+	logname := filepath.Join(tmp, jobname+".log")
+	var logContent []byte
+	for pass := 1; ; pass++ {
+		cmd := pdfCommand(ctx, abs, engine, engineArgs)
+		cmd.Dir = tmp
+		// Files referenced by the document such as images and chapters are looked up next to it.
+		cmd.Env = append(os.Environ(), "TEXINPUTS="+filepath.Dir(abs)+string(filepath.ListSeparator))
+		verb("running", cmd.String())
+		out, runErr := cmd.CombinedOutput()
+		logContent, err = os.ReadFile(logname)
+		if err != nil && runErr == nil {
+			return fmt.Errorf("%s: reading log: %w", texname, err)
+		}
+		if runErr != nil {
+			return pdfError(texname, cmd.Path, runErr, out, logContent, maps)
+		}
+		rerun := bytes.Contains(logContent, []byte("Rerun to get")) || pass == 1 && exists(filepath.Join(tmp, jobname+".toc"))
+		if latexmk != "" || !rerun || pass == maxPasses {
+			break
+		}
+	}
+	summarizeLog(texname, logContent, maps)
+	pdfname := strings.TrimSuffix(texname, filepath.Ext(texname)) + ".pdf"
+	b, err := os.ReadFile(filepath.Join(tmp, jobname+".pdf"))
+	if err != nil {
+		return fmt.Errorf("%s: no PDF output: %w", texname, err)
+	}
+	verb("writing", pdfname)
+	return os.WriteFile(pdfname, b, 0666)
+}
+
+// pdfCommand returns the command that typesets texname with engine, using latexmk if set.
+func pdfCommand(ctx context.Context, texname string, engine latex.Engine, extra []string) *exec.Cmd {
+	args := append(append([]string{}, extra...), "-interaction=nonstopmode", "-halt-on-error", "-file-line-error")
+	if latexmk == "" {
+		return exec.CommandContext(ctx, string(engine), append(args, texname)...)
+	}
+	mode := "-pdf"
+	switch engine {
+	case latex.XeLaTeX:
+		mode = "-xelatex"
+	case latex.LuaLaTeX:
+		mode = "-lualatex"
+	}
+	return exec.CommandContext(ctx, latexmk, append([]string{mode}, append(args, texname)...)...)
+}
+
+// pdfError returns the error of a failed engine run, described by the
+// first error found in the log or else the end of the engine output.
+func pdfError(texname, engine string, err error, out, logContent []byte, maps map[string]latex.SourceMap) error {
+	diags := mapLog(texname, logContent, maps)
+	for _, d := range diags {
+		if d.Kind == latexlog.Error {
+			return fmt.Errorf("%s: %s", filepath.Base(engine), d)
+		}
+	}
+	out = bytes.TrimSpace(out)
+	if i := len(out) - 500; i > 0 {
+		out = out[i:]
+	}
+	return fmt.Errorf("%s: %s failed: %w\n%s", texname, filepath.Base(engine), err, out)
+}
+
+// summarizeLog prints the problems found in the log of texname to stderr.
+// Bad boxes are only printed in verbose mode.
+func summarizeLog(texname string, logContent []byte, maps map[string]latex.SourceMap) {
+	var errs, warnings, boxes int
+	for _, d := range mapLog(texname, logContent, maps) {
+		switch d.Kind {
+		case latexlog.Error:
+			errs++
+		case latexlog.BadBox:
+			boxes++
+			if !verbose {
+				continue
+			}
+		default:
+			warnings++
+		}
+		fmt.Fprintln(os.Stderr, d)
+	}
+	verb(fmt.Sprintf("%s: %d errors, %d warnings, %d bad boxes", texname, errs, warnings, boxes))
+}
+
+// mapLog parses a TeX log and maps its entries to markdown positions with the source
+// map of the file each entry was found in. Entries of other files, such as packages, are kept as is.
+func mapLog(texname string, logContent []byte, maps map[string]latex.SourceMap) []latexlog.Diagnostic {
+	entries, err := latexlog.Parse(bytes.NewReader(logContent))
+	if err != nil {
+		verb("parsing log:", err)
+	}
+	diags := make([]latexlog.Diagnostic, len(entries))
+	for i, e := range entries {
+		file := filepath.Base(texname)
+		if e.File != "" {
+			file = filepath.Base(e.File)
+		}
+		sm, ok := maps[file]
+		if !ok {
+			diags[i] = latexlog.Diagnostic{Entry: e}
+			continue
+		}
+		diags[i] = latexlog.Map(entries[i:i+1], file, sm)[0]
+	}
+	return diags
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
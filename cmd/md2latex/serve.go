@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	latex "github.com/soypat/goldmark-latex"
+)
+
+// server converts markdown posted to /convert to LaTeX and, if enabled, to PDF at /pdf.
+// Options are given as query parameters or as fields of a JSON body alongside
+// the "markdown" field, named after the flags, i.e: ?class=report&toc=true.
+type server struct {
+	// Extensions and renderer configuration requests start from.
+	ext      latex.ExtensionConfig
+	maxBytes int64
+	timeout  time.Duration
+	pdf      bool
+	// pdfSlots limits the number of PDFs typeset at once.
+	pdfSlots chan struct{}
+}
+
+// runServe parses the serve subcommand flags in args and serves conversions until an error occurs.
+// The flags given before the subcommand, such as -engine or -preamble, set the default configuration.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "Address to listen on.")
+	maxBytes := fs.Int64("maxbytes", 1<<20, "Maximum size of a request body in bytes.")
+	timeout := fs.Duration("timeout", 30*time.Second, "Maximum time to read, convert and respond to a request.")
+	servePDF := fs.Bool("pdf", false, "Serve POST /pdf, typesetting with the engine set by -engine or with -latexmk.")
+	pdfJobs := fs.Int("pdfjobs", runtime.NumCPU(), "Maximum number of PDFs typeset at once, further /pdf requests fail with 503 Service Unavailable.")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if usehtml {
+		return errors.New("serve does not support -html")
+	}
+	s, err := newServer(*maxBytes, *timeout, *servePDF, *pdfJobs)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       *timeout,
+		WriteTimeout:      *timeout + 5*time.Second, // Longer than the handler timeout so it can respond.
+	}
+	log.Println("listening on", *addr)
+	return srv.ListenAndServe()
+}
+
+// newServer returns a server with the configuration set by flags that typesets
+// up to pdfJobs PDFs at once. Unsafe rendering is always disabled.
+func newServer(maxBytes int64, timeout time.Duration, pdf bool, pdfJobs int) (*server, error) {
+	cfg, err := latexConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Unsafe = false
+	cfg.LineMarkers = false
+	ext, err := extensionConfig()
+	if err != nil {
+		return nil, err
+	}
+	ext.Config = cfg
+	if pdfJobs < 1 {
+		pdfJobs = 1
+	}
+	return &server{ext: ext, maxBytes: maxBytes, timeout: timeout, pdf: pdf, pdfSlots: make(chan struct{}, pdfJobs)}, nil
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/convert", s.handleConvert)
+	if s.pdf {
+		mux.HandleFunc("/pdf", s.handlePDF)
+	}
+	return http.TimeoutHandler(mux, s.timeout, "conversion timed out\n")
+}
+
+func (s *server) handleConvert(w http.ResponseWriter, r *http.Request) {
+	ext, markdown, ok := s.request(w, r)
+	if !ok {
+		return
+	}
+	output, _, err := renderGoldmark(ext, markdown)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tex; charset=utf-8")
+	_, _ = w.Write(output)
+}
+
+func (s *server) handlePDF(w http.ResponseWriter, r *http.Request) {
+	ext, markdown, ok := s.request(w, r)
+	if !ok {
+		return
+	}
+	if ext.Config.Fragment {
+		http.Error(w, "fragment can not be typeset", http.StatusBadRequest)
+		return
+	}
+	select {
+	case s.pdfSlots <- struct{}{}:
+		defer func() { <-s.pdfSlots }()
+	default:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many PDF requests in progress", http.StatusServiceUnavailable)
+		return
+	}
+	ext.Config.LineMarkers = true
+	output, _, err := renderGoldmark(ext, markdown)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	output, sm := latex.ExtractSourceMap(output, false)
+	sm.Source = "document.md"
+	dir, err := os.MkdirTemp("", "md2latex-serve-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+	texname := filepath.Join(dir, "document.tex")
+	err = os.WriteFile(texname, output, 0666)
+	if err == nil {
+		// Documents must not run shell commands on the server.
+		err = buildPDF(r.Context(), texname, ext.Config.Engine, map[string]latex.SourceMap{"document.tex": sm}, "-no-shell-escape")
+	}
+	if err != nil {
+		// TeX errors are caused by the document, report them to the client.
+		http.Error(w, strings.ReplaceAll(err.Error(), dir+string(filepath.Separator), ""), http.StatusUnprocessableEntity)
+		return
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "document.pdf"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	_, _ = w.Write(b)
+}
+
+// request reads the markdown and options of a conversion request and returns the
+// configuration to convert it with. If the request is invalid an error is written
+// to w and ok is false. A JSON body holds the markdown in the "markdown" field,
+// any other body is markdown.
+func (s *server) request(w http.ResponseWriter, r *http.Request) (ext latex.ExtensionConfig, markdown []byte, ok bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return ext, nil, false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("request body larger than %d bytes", s.maxBytes), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return ext, nil, false
+	}
+	options := r.URL.Query()
+	markdown = body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		markdown, err = decodeRequest(body, options)
+		if err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return ext, nil, false
+		}
+	}
+	ext = s.ext
+	for key, values := range options {
+		for _, value := range values {
+			err = setOption(&ext, key, value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("option %s: %v", key, err), http.StatusBadRequest)
+				return ext, nil, false
+			}
+		}
+	}
+	return ext, markdown, true
+}
+
+// decodeRequest returns the markdown of a JSON request body and adds its other fields to options.
+func decodeRequest(body []byte, options url.Values) ([]byte, error) {
+	var fields map[string]interface{}
+	err := json.Unmarshal(body, &fields)
+	if err != nil {
+		return nil, err
+	}
+	markdown, ok := fields["markdown"].(string)
+	if !ok {
+		return nil, errors.New(`missing "markdown" string field`)
+	}
+	for key, value := range fields {
+		switch v := value.(type) {
+		case string:
+			options.Set(key, v)
+		case []interface{}:
+			parts := make([]string, len(v))
+			for i := range v {
+				parts[i] = fmt.Sprint(v[i])
+			}
+			options.Set(key, strings.Join(parts, ","))
+		case map[string]interface{}, nil:
+			return nil, fmt.Errorf("%s must be a string, number, boolean or list", key)
+		default:
+			options.Set(key, fmt.Sprint(v))
+		}
+	}
+	options.Del("markdown")
+	return []byte(markdown), nil
+}
+
+// setOption sets the conversion option key, named after the flag, to value.
+// Options reading files or rendering unsafe content are not available, and
+// values written to the preamble are restricted so they can not inject LaTeX.
+func setOption(ext *latex.ExtensionConfig, key, value string) error {
+	cfg := &ext.Config
+	switch key {
+	case "class":
+		switch latex.DocumentClass(value) {
+		case latex.ClassArticle, latex.ClassReport, latex.ClassBook, latex.ClassMemoir,
+			latex.ClassScrartcl, latex.ClassScrreprt, latex.ClassScrbook:
+			cfg.DocumentClass = latex.DocumentClass(value)
+		default:
+			return fmt.Errorf("unknown document class %q", value)
+		}
+	case "lang":
+		if value != "" && !latex.KnownLanguage(value) {
+			return fmt.Errorf("unknown language %q", value)
+		}
+		cfg.Language = value
+	case "labelprefix":
+		cfg.LabelPrefix = value
+	case "engine":
+		switch latex.Engine(value) {
+		case latex.PDFLaTeX, latex.XeLaTeX, latex.LuaLaTeX:
+			cfg.Engine = latex.Engine(value)
+		default:
+			return fmt.Errorf("unknown engine %q", value)
+		}
+	case "headingoffset":
+		offset, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		cfg.HeadingLevelOffset = offset
+	case "ext":
+		for _, name := range strings.Split(value, ",") {
+			err := enableExtension(ext, name)
+			if err != nil {
+				return err
+			}
+		}
+	case "beamer", "unicode", "inlineunicode", "gfm":
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "beamer":
+			cfg.Mode = latex.Document
+			if on {
+				cfg.Mode = latex.Beamer
+			}
+		case "unicode", "inlineunicode":
+			if key == "inlineunicode" {
+				cfg.InlineUnicode = on
+			}
+			cfg.DeclareUnicode = nil
+			if on || cfg.InlineUnicode {
+				cfg.DeclareUnicode = latex.UnicodeToLaTeX
+			}
+		case "gfm":
+			ext.Table, ext.Strikethrough, ext.TaskList, ext.Linkify = on, on, on, on
+		}
+	default:
+		if name := nameOption(cfg, key); name != nil {
+			if strings.Trim(value, nameChars) != "" {
+				return fmt.Errorf("%q must only contain letters, digits, spaces and hyphens", value)
+			}
+			*name = value
+			return nil
+		}
+		p := boolOption(ext, key)
+		if p == nil {
+			return errors.New("unknown option")
+		}
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = on
+	}
+	return nil
+}
+
+// nameChars are the characters allowed in theme and font names.
+const nameChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789 -"
+
+// nameOption returns the theme or font name option named key in cfg, or nil if there is none.
+func nameOption(cfg *latex.Config, key string) *string {
+	switch key {
+	case "theme":
+		return &cfg.BeamerTheme
+	case "mainfont":
+		return &cfg.Fonts.Main
+	case "sansfont":
+		return &cfg.Fonts.Sans
+	case "monofont":
+		return &cfg.Fonts.Mono
+	case "mathfont":
+		return &cfg.Fonts.Math
+	case "cjkfont":
+		return &cfg.Fonts.CJK
+	case "hebrewfont":
+		return &cfg.Fonts.Hebrew
+	case "arabicfont":
+		return &cfg.Fonts.Arabic
+	}
+	return nil
+}
+
+// boolOption returns the boolean option named key in ext, or nil if there is none.
+func boolOption(ext *latex.ExtensionConfig, key string) *bool {
+	cfg := &ext.Config
+	switch key {
+	case "part":
+		return &cfg.UsePart
+	case "unhead":
+		return &cfg.NoHeadingNumbering
+	case "toc":
+		return &cfg.TOC
+	case "fullpreamble":
+		return &cfg.FullPreamble
+	case "fragment":
+		return &cfg.Fragment
+	case "footnotes":
+		return &ext.Footnote
+	case "deflist":
+		return &ext.DefinitionList
+	case "typographer":
+		return &ext.Typographer
+	case "math":
+		return &ext.Math
+	case "meta":
+		return &ext.FrontMatter
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// watchInterval is the interval at which watched files are polled for changes.
+	watchInterval = 500 * time.Millisecond
+	// watchDebounce is how long files must remain unchanged after a change
+	// before rendering, so that a burst of saves renders once.
+	watchDebounce = 200 * time.Millisecond
+)
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watch renders args and the directory tree set by -r and renders them again whenever
+// a markdown file, the preamble, the template or the configuration file changes until
+// stop is closed. The configuration file is loaded again before rendering.
+// Render errors are printed and watching continues.
+func watch(args []string, stop <-chan struct{}) error {
+	if len(args) == 0 && recurseDir == "" {
+		return errors.New("missing filename argument")
+	}
+	targets := args
+	if recurseDir != "" {
+		targets = append(append([]string{}, args...), recurseDir)
+	}
+	verb("watching", watchedFiles(args))
+	stamps := fileStamps(watchedFiles(args))
+	render := func() {
+		start := time.Now()
+		if project.filename != "" {
+			err := reloadConfig(flag.CommandLine)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+		}
+		err := run(args)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		log.Println("rendered", targets, "in", time.Since(start).Round(time.Millisecond))
+	}
+	render()
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		current := fileStamps(watchedFiles(args))
+		if equalStamps(stamps, current) {
+			continue
+		}
+		// Wait for the files to settle.
+		for {
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(watchDebounce):
+			}
+			stamps = current
+			current = fileStamps(watchedFiles(args))
+			if equalStamps(stamps, current) {
+				break
+			}
+		}
+		render()
+	}
+}
+
+// watchedFiles returns args, the markdown files under recurseDir and the
+// preamble, template and configuration files if set.
+func watchedFiles(args []string) []string {
+	files := append([]string{}, args...)
+	if recurseDir != "" {
+		_ = filepath.WalkDir(recurseDir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".md") {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	for _, name := range []string{preambleFilename, templateFilename, project.filename} {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// fileStamps returns the stamps of the named files that exist.
+func fileStamps(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err == nil {
+			stamps[name] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return stamps
+}
+
+func equalStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, sa := range a {
+		sb, ok := b[name]
+		if !ok || !sa.modTime.Equal(sb.modTime) || sa.size != sb.size {
+			return false
+		}
+	}
+	return true
+}
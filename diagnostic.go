@@ -0,0 +1,136 @@
+package latex
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// Severity of a Diagnostic.
+type Severity uint8
+
+const (
+	// SeverityInfo diagnostics report changes made to the output, such as added packages.
+	SeverityInfo Severity = iota
+	// SeverityWarning diagnostics report markdown content dropped or degraded in the output,
+	// such as skipped HTML or code blocks rendered without highlighting.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "info"
+}
+
+// Stable Diagnostic codes.
+const (
+	CodeHTMLBlock       = "html-block"       // HTML block skipped.
+	CodeRawHTML         = "raw-html"         // Inline HTML skipped.
+	CodeImage           = "image"            // Image skipped.
+	CodeUnsafeLine      = "unsafe-line"      // Code block line commented out, see Config.Unsafe.
+	CodeUnsafeMath      = "unsafe-math"      // Math skipped, see Config.Unsafe.
+	CodeUnknownLanguage = "unknown-language" // Code block language not supported by listings.
+	CodeUnknownKind     = "unknown-kind"     // Node of a kind unknown to the Renderer, see RegisterFuncs.
+	CodeScriptEngine    = "script-engine"    // Text in a script not supported by the engine.
+	CodeMissingPackage  = "missing-package"  // Package added to a custom preamble.
+	CodeInvalidLanguage = "invalid-language" // Language that is not a language name ignored.
+)
+
+// Diagnostic is a problem found while rendering a document.
+type Diagnostic struct {
+	Severity Severity
+	// Kind of the node the problem was found in. Zero for problems not related to a node.
+	Kind ast.NodeKind
+	// Code identifies the problem, see the Code constants.
+	Code    string
+	Message string
+	// Position in the markdown source, starting at 1. Zero if unknown.
+	// Column counts bytes.
+	Line, Column int
+}
+
+// String returns the diagnostic formatted as "LINE:COL: severity: message".
+func (d Diagnostic) String() string {
+	return strconv.Itoa(d.Line) + ":" + strconv.Itoa(d.Column) + ": " + d.Severity.String() + ": " + d.Message
+}
+
+// DiagnosticCollector collects diagnostics. Its Report method can be used as
+// Config.OnDiagnostic. It is safe for concurrent use.
+type DiagnosticCollector struct {
+	mu    sync.Mutex
+	diags []Diagnostic
+}
+
+// Report adds d to the collected diagnostics.
+func (c *DiagnosticCollector) Report(d Diagnostic) {
+	c.mu.Lock()
+	c.diags = append(c.diags, d)
+	c.mu.Unlock()
+}
+
+// Diagnostics returns a copy of the collected diagnostics in order of report.
+func (c *DiagnosticCollector) Diagnostics() []Diagnostic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Diagnostic(nil), c.diags...)
+}
+
+// Reset discards the collected diagnostics.
+func (c *DiagnosticCollector) Reset() {
+	c.mu.Lock()
+	c.diags = c.diags[:0]
+	c.mu.Unlock()
+}
+
+// diagnose reports a diagnostic for node n, which may be nil, to Config.OnDiagnostic.
+func (r *Renderer) diagnose(source []byte, n ast.Node, severity Severity, code, msg string) {
+	if r.Config.OnDiagnostic == nil {
+		return
+	}
+	d := Diagnostic{Severity: severity, Code: code, Message: msg}
+	if n != nil {
+		d.Kind = n.Kind()
+		d.Line, d.Column = position(source, nodeOffset(n))
+	}
+	r.Config.OnDiagnostic(d)
+}
+
+// nodeOffset returns the offset in the source of the first segment of n or
+// of its first descendant with segments. Returns -1 if none is found.
+func nodeOffset(n ast.Node) int {
+	switch n := n.(type) {
+	case *ast.FencedCodeBlock:
+		if n.Info != nil {
+			return n.Info.Segment.Start // On the opening fence line.
+		}
+	case *ast.Text:
+		return n.Segment.Start
+	case *ast.RawHTML:
+		if n.Segments.Len() > 0 {
+			return n.Segments.At(0).Start
+		}
+	}
+	if n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+		return n.Lines().At(0).Start
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if off := nodeOffset(c); off >= 0 {
+			return off
+		}
+	}
+	return -1
+}
+
+// position returns the 1-based line and column of offset in source.
+// Returns zeros if offset is negative or past the end of source.
+func position(source []byte, offset int) (line, column int) {
+	if offset < 0 || offset > len(source) {
+		return 0, 0
+	}
+	before := source[:offset]
+	return bytes.Count(before, []byte{'\n'}) + 1, offset - bytes.LastIndexByte(before, '\n')
+}
@@ -0,0 +1,32 @@
+package latex
+
+// Engine is a TeX engine such as pdflatex.
+type Engine string
+
+// TeX engines supported by the renderer.
+const (
+	PDFLaTeX Engine = "pdflatex"
+	XeLaTeX  Engine = "xelatex"
+	LuaLaTeX Engine = "lualatex"
+)
+
+// Unicode reports whether the engine reads UTF-8 input natively and uses
+// system fonts through fontspec.
+func (e Engine) Unicode() bool {
+	return e == XeLaTeX || e == LuaLaTeX
+}
+
+// Fonts are font family names as understood by fontspec, i.e. "TeX Gyre Heros".
+type Fonts struct {
+	Main string
+	Sans string
+	Mono string
+	Math string
+	// Font for Chinese, Japanese and Korean text. With pdflatex this is a
+	// CJKutf8 family such as gbsn, bsmi, min or mj. Chosen based on the text if empty.
+	CJK string
+	// Fonts for Hebrew and Arabic text. Only used with Unicode engines.
+	// Default to Noto Sans Hebrew and Noto Naskh Arabic.
+	Hebrew string
+	Arabic string
+}
@@ -0,0 +1,325 @@
+package latex
+
+import (
+	"strconv"
+
+	"github.com/yuin/goldmark"
+	meta "github.com/yuin/goldmark-meta"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// ExtensionConfig selects the parser extensions enabled by Extension
+// alongside the LaTeX renderer. The Renderer renders the nodes of all of them.
+type ExtensionConfig struct {
+	// Configuration of the Renderer.
+	Config Config
+	// GitHub flavored markdown tables, rendered as tabular.
+	Table bool
+	// ~~Strikethrough~~, rendered with \sout.
+	Strikethrough bool
+	// Task list items such as "- [x] done".
+	TaskList bool
+	// URLs and www. addresses in text are turned into links.
+	Linkify bool
+	// Footnotes such as [^1], rendered as \footnote.
+	Footnote bool
+	// PHP Markdown Extra definition lists, rendered as description environments.
+	DefinitionList bool
+	// Replaces quotes, dashes and ellipses with their typographic LaTeX
+	// equivalents such as ``quoted'', -- and \ldots{}.
+	Typographer bool
+	// TeX math between $ and $$ delimiters, written as is. Unless Config.Unsafe
+	// is set, math using commands other than those of LaTeX, amsmath and amssymb is skipped.
+	Math bool
+	// YAML front matter. The title, author, date, toc and lang keys are used
+	// by the document template, see TemplateData.
+	FrontMatter bool
+}
+
+// SetLatexExtensionOption implements the ExtensionOption interface.
+func (e ExtensionConfig) SetLatexExtensionOption(c *ExtensionConfig) { *c = e }
+
+// SetLatexExtensionOption implements the ExtensionOption interface by setting the
+// configuration of the Renderer.
+func (r Config) SetLatexExtensionOption(c *ExtensionConfig) { c.Config = r }
+
+// An ExtensionOption configures Extension.
+type ExtensionOption interface {
+	SetLatexExtensionOption(*ExtensionConfig)
+}
+
+// Extension returns a goldmark.Extender that renders LaTeX and enables the
+// parser extensions selected by opts. Options are applied in order of appearance.
+// Example:
+//
+//	md := goldmark.New(goldmark.WithExtensions(latex.Extension(latex.ExtensionConfig{
+//		Config: latex.Config{DocumentClass: latex.ClassReport},
+//		Table:  true,
+//		Math:   true,
+//	})))
+//	md.Convert(markdown, LaTeXoutput)
+//
+// The goldmark renderer is replaced by one that renders LaTeX, so Extension
+// should come before other extensions whose node renderers are needed.
+// Headings are given automatic ids and labeled, see Config.LabelPrefix.
+func Extension(opts ...ExtensionOption) goldmark.Extender {
+	e := &latexExtension{}
+	for _, opt := range opts {
+		opt.SetLatexExtensionOption(&e.config)
+	}
+	return e
+}
+
+type latexExtension struct {
+	config ExtensionConfig
+}
+
+// Extend implements goldmark.Extender.
+func (e *latexExtension) Extend(m goldmark.Markdown) {
+	// Priority is lower than the 500 of HTML renderers added by goldmark's extensions.
+	m.SetRenderer(renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(NewRenderer(e.config.Config), 100))))
+	// Headings need ids to be labeled, else links to #fragments refer to no \label.
+	m.Parser().AddOptions(parser.WithAutoHeadingID())
+	cfg := e.config
+	if cfg.Table {
+		m.Parser().AddOptions(
+			parser.WithParagraphTransformers(util.Prioritized(extension.NewTableParagraphTransformer(), 200)),
+			parser.WithASTTransformers(util.Prioritized(extension.NewTableASTTransformer(), 0)),
+		)
+	}
+	if cfg.Strikethrough {
+		m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(extension.NewStrikethroughParser(), 500)))
+	}
+	if cfg.TaskList {
+		m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(extension.NewTaskCheckBoxParser(), 0)))
+	}
+	if cfg.Linkify {
+		m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(extension.NewLinkifyParser(), 999)))
+	}
+	if cfg.Footnote {
+		m.Parser().AddOptions(
+			parser.WithBlockParsers(util.Prioritized(extension.NewFootnoteBlockParser(), 999)),
+			parser.WithInlineParsers(util.Prioritized(extension.NewFootnoteParser(), 101)),
+			parser.WithASTTransformers(
+				util.Prioritized(extension.NewFootnoteASTTransformer(), 999),
+				util.Prioritized(footnoteTransformer{}, 1000), // After the footnote list is built.
+			),
+		)
+	}
+	if cfg.DefinitionList {
+		m.Parser().AddOptions(parser.WithBlockParsers(
+			util.Prioritized(extension.NewDefinitionListParser(), 101),
+			util.Prioritized(extension.NewDefinitionDescriptionParser(), 102),
+		))
+	}
+	if cfg.Typographer {
+		m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(
+			extension.NewTypographerParser(extension.WithTypographicSubstitutions(typographicSubstitutions)), 9999)))
+	}
+	if cfg.Math {
+		m.Parser().AddOptions(
+			parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 150)),
+			parser.WithInlineParsers(util.Prioritized(mathParser{}, 150)),
+		)
+	}
+	if cfg.FrontMatter {
+		meta.New(meta.WithStoresInDocument()).Extend(m)
+	}
+}
+
+// typographicSubstitutions replace the HTML entities written by goldmark's typographer.
+var typographicSubstitutions = map[extension.TypographicPunctuation][]byte{
+	extension.LeftSingleQuote:  []byte("`"),
+	extension.RightSingleQuote: []byte("'"),
+	extension.LeftDoubleQuote:  []byte("``"),
+	extension.RightDoubleQuote: []byte("''"),
+	extension.EnDash:           []byte("--"),
+	extension.EmDash:           []byte("---"),
+	extension.Ellipsis:         []byte("\\ldots{}"),
+	extension.LeftAngleQuote:   []byte("\\guillemotleft{}"),
+	extension.RightAngleQuote:  []byte("\\guillemotright{}"),
+	extension.Apostrophe:       []byte("'"),
+}
+
+// footnoteTransformer moves the contents of each footnote to its first reference
+// so that the Renderer can write it as \footnote{...} where it is referenced.
+// Paragraphs are separated with \par. Other references are written as \footnotemark
+// and footnotes containing other blocks are left in the footnote list.
+type footnoteTransformer struct{}
+
+// Transform implements parser.ASTTransformer.
+func (footnoteTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	list, ok := doc.LastChild().(*east.FootnoteList)
+	if !ok {
+		return
+	}
+	footnotes := make(map[int]*east.Footnote)
+	for n := list.FirstChild(); n != nil; n = n.NextSibling() {
+		if fn, ok := n.(*east.Footnote); ok {
+			footnotes[fn.Index] = fn
+		}
+	}
+	var links []*east.FootnoteLink
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if link, ok := n.(*east.FootnoteLink); ok && entering && link.RefIndex == 0 {
+			links = append(links, link)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, link := range links {
+		fn := footnotes[link.Index]
+		if fn == nil || !inlineFootnote(fn) {
+			continue
+		}
+		for block := fn.FirstChild(); block != nil; block = block.NextSibling() {
+			if block != fn.FirstChild() {
+				par := ast.NewString([]byte("\\par "))
+				par.SetRaw(true)
+				link.AppendChild(link, par)
+			}
+			for c := block.FirstChild(); c != nil; {
+				next := c.NextSibling()
+				if c.Type() == ast.TypeInline && c.Kind() != east.KindFootnoteBacklink {
+					link.AppendChild(link, c)
+				}
+				c = next
+			}
+		}
+		list.RemoveChild(list, fn)
+	}
+	if !list.HasChildren() {
+		doc.RemoveChild(doc, list)
+	}
+}
+
+// inlineFootnote reports whether fn only contains paragraphs.
+func inlineFootnote(fn *east.Footnote) bool {
+	for block := fn.FirstChild(); block != nil; block = block.NextSibling() {
+		if block.Kind() != ast.KindParagraph && block.Kind() != ast.KindTextBlock {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Renderer) renderTable(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*east.Table)
+	if entering {
+		_, _ = w.Write(tableStart)
+		_, _ = w.WriteString("\\centering\n\\begin{tabular}{")
+		for _, align := range n.Alignments {
+			switch align {
+			case east.AlignRight:
+				_ = w.WriteByte('r')
+			case east.AlignCenter:
+				_ = w.WriteByte('c')
+			default:
+				_ = w.WriteByte('l')
+			}
+		}
+		_, _ = w.WriteString("}\n\\hline\n")
+	} else {
+		_, _ = w.WriteString("\\hline\n\\end{tabular}")
+		_, _ = w.Write(tableEnd)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *Renderer) renderTableRow(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		_, _ = w.WriteString(" \\\\\n")
+		if n.Kind() == east.KindTableHeader {
+			_, _ = w.WriteString("\\hline\n")
+		}
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *Renderer) renderTableCell(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering && n.PreviousSibling() != nil {
+		_, _ = w.WriteString(" & ")
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *Renderer) renderStrikethrough(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		_, _ = w.Write(strikeStart)
+	} else {
+		_ = w.WriteByte('}')
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *Renderer) renderTaskCheckBox(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		if node.(*east.TaskCheckBox).IsChecked {
+			_, _ = w.WriteString("$\\boxtimes$ ")
+		} else {
+			_, _ = w.WriteString("$\\square$ ")
+		}
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *Renderer) renderFootnoteLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*east.FootnoteLink)
+	switch {
+	case n.HasChildren() && entering:
+		_, _ = w.WriteString("\\footnote{")
+	case n.HasChildren():
+		_ = w.WriteByte('}')
+	case entering:
+		_, _ = w.WriteString("\\footnotemark[" + strconv.Itoa(n.Index) + "]")
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *Renderer) renderFootnote(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*east.Footnote)
+	if entering {
+		_, _ = w.WriteString("\n\\footnotetext[" + strconv.Itoa(n.Index) + "]{")
+	} else {
+		_, _ = w.WriteString("}\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *Renderer) renderFootnoteBacklink(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *Renderer) renderDefinitionList(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		_, _ = w.WriteString("\n\\begin{description}\n")
+	} else {
+		_, _ = w.WriteString("\\end{description}\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *Renderer) renderDefinitionTerm(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		_, _ = w.WriteString("\\item[{")
+	} else {
+		_, _ = w.WriteString("}] ")
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *Renderer) renderDefinitionDescription(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		_ = w.WriteByte('\n')
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *Renderer) renderFootnoteList(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,57 @@
+package latex
+
+import (
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// registerFallback registers renderFallback for every node kind known when it is called.
+// The Renderer's own render functions must be registered afterwards.
+func (r *Renderer) registerFallback(reg renderer.NodeRendererFuncRegisterer) {
+	block, inline := r.block(r.renderFallback), r.inline(r.renderFallback)
+	fallback := func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if n.Type() == ast.TypeInline {
+			return inline(w, source, n, entering)
+		}
+		return block(w, source, n, entering)
+	}
+	for _, kind := range nodeKinds() {
+		reg.Register(kind, fallback)
+	}
+}
+
+// nodeKinds returns all node kinds created with ast.NewNodeKind so far.
+func nodeKinds() (kinds []ast.NodeKind) {
+	defer func() {
+		recover() // NodeKind.String panics past the last kind.
+	}()
+	for kind := ast.NodeKind(0); ; kind++ {
+		_ = kind.String()
+		kinds = append(kinds, kind)
+	}
+}
+
+// renderFallback renders nodes of kinds unknown to the Renderer, usually added
+// by a goldmark extension. Blocks are rendered like paragraphs and inlines
+// as their children. A comment naming the kind is written.
+func (r *Renderer) renderFallback(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if n.Type() == ast.TypeInline {
+		if entering {
+			// The newline ends the comment without adding space to the text.
+			_, _ = w.WriteString("% goldmark-latex: unsupported inline " + n.Kind().String() + " rendered as text\n")
+			r.diagnose(source, n, SeverityWarning, CodeUnknownKind, "unsupported inline "+n.Kind().String()+" rendered as text")
+		}
+		return ast.WalkContinue, nil
+	}
+	if entering {
+		_, _ = w.WriteString("\n% goldmark-latex: unsupported block " + n.Kind().String() + " rendered as paragraph\n")
+		r.diagnose(source, n, SeverityWarning, CodeUnknownKind, "unsupported block "+n.Kind().String()+" rendered as paragraph")
+		if !n.HasChildren() {
+			r.writeLines(w, source, n)
+		}
+	} else if n.HasChildren() && n.FirstChild().Type() == ast.TypeInline || !n.HasChildren() && n.Lines().Len() > 0 {
+		_, _ = w.Write(hardBreak)
+	}
+	return ast.WalkContinue, nil
+}
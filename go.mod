@@ -2,4 +2,10 @@ module github.com/soypat/goldmark-latex
 
 go 1.19
 
-require github.com/yuin/goldmark v1.4.14
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/yuin/goldmark v1.4.14
+	github.com/yuin/goldmark-meta v1.1.0
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v2 v2.4.0
+)
@@ -0,0 +1,90 @@
+package latex
+
+import (
+	"bufio"
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// Hook is called when entering and exiting nodes of the kind it is registered
+// for in Config.Hooks. When entering, the hook is called before the node is
+// rendered. When exiting, whatever the hook writes is written after the node is
+// rendered. The returned HookResult selects what happens with the default rendering.
+//
+// For example, to start every chapter on a new page:
+//
+//	Hooks: map[ast.NodeKind]latex.Hook{
+//		ast.KindHeading: func(w util.BufWriter, source []byte, n ast.Node, entering bool) (latex.HookResult, error) {
+//			if entering && n.(*ast.Heading).Level == 1 {
+//				w.WriteString("\n\\clearpage")
+//			}
+//			return latex.HookContinue, nil
+//		},
+//	}
+type Hook func(w util.BufWriter, source []byte, n ast.Node, entering bool) (HookResult, error)
+
+// HookResult is returned by a Hook.
+type HookResult uint8
+
+const (
+	// HookContinue renders the node as usual.
+	HookContinue HookResult = iota
+	// HookReplace skips the default rendering of the node when entering
+	// or exiting. Children are still rendered. A hook replacing the
+	// rendering of a node should return HookReplace both when entering and exiting.
+	HookReplace
+	// HookSkipChildren skips the default rendering of the node and its
+	// children. The hook is still called when exiting the node, where it
+	// should return HookReplace.
+	HookSkipChildren
+)
+
+// hookRegisterer registers render functions that call the Renderer's hooks.
+type hookRegisterer struct {
+	reg renderer.NodeRendererFuncRegisterer
+	r   *Renderer
+}
+
+// Register implements renderer.NodeRendererFuncRegisterer.
+func (hr hookRegisterer) Register(kind ast.NodeKind, fn renderer.NodeRendererFunc) {
+	hr.reg.Register(kind, hr.r.hook(kind, fn))
+}
+
+// hook wraps the render function of a node kind with the hook configured for it.
+func (r *Renderer) hook(kind ast.NodeKind, fn renderer.NodeRendererFunc) renderer.NodeRendererFunc {
+	return func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		hook := r.Config.Hooks[kind]
+		if hook == nil {
+			return fn(w, source, n, entering)
+		}
+		if entering {
+			result, err := hook(w, source, n, entering)
+			switch {
+			case err != nil:
+				return ast.WalkStop, err
+			case result == HookReplace:
+				return ast.WalkContinue, nil
+			case result == HookSkipChildren:
+				return ast.WalkSkipChildren, nil
+			}
+			return fn(w, source, n, entering)
+		}
+		// Buffer the hook's output to write it after the node's default exit.
+		var b bytes.Buffer
+		bw := bufio.NewWriter(&b)
+		result, err := hook(bw, source, n, entering)
+		if err != nil {
+			return ast.WalkStop, err
+		}
+		_ = bw.Flush()
+		status := ast.WalkContinue
+		if result == HookContinue {
+			status, err = fn(w, source, n, entering)
+		}
+		_, _ = w.Write(b.Bytes())
+		return status, err
+	}
+}
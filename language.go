@@ -0,0 +1,224 @@
+package latex
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// languageNames maps BCP 47 language tags to babel and polyglossia language names.
+var languageNames = map[string][2]string{
+	"en":    {"english", "english"},
+	"en-us": {"american", "english"},
+	"en-gb": {"british", "english"},
+	"es":    {"spanish", "spanish"},
+	"de":    {"ngerman", "german"},
+	"fr":    {"french", "french"},
+	"it":    {"italian", "italian"},
+	"pt":    {"portuguese", "portuguese"},
+	"pt-br": {"brazilian", "portuguese"},
+	"nl":    {"dutch", "dutch"},
+	"ca":    {"catalan", "catalan"},
+	"da":    {"danish", "danish"},
+	"sv":    {"swedish", "swedish"},
+	"nb":    {"norsk", "norwegian"},
+	"no":    {"norsk", "norwegian"},
+	"fi":    {"finnish", "finnish"},
+	"pl":    {"polish", "polish"},
+	"cs":    {"czech", "czech"},
+	"ru":    {"russian", "russian"},
+	"uk":    {"ukrainian", "ukrainian"},
+	"el":    {"greek", "greek"},
+	"tr":    {"turkish", "turkish"},
+	"he":    {"hebrew", "hebrew"},
+	"ar":    {"arabic", "arabic"},
+}
+
+// languageName returns the babel or polyglossia name, depending on the engine,
+// of a BCP 47 language tag such as "es" or "pt-BR". Unknown tags made of letters, which may
+// already be language names such as "spanish", are returned lowercased. An empty
+// string is returned for any other tag so that it is not written to the output.
+func (r *Renderer) languageName(tag string) string {
+	tag = normalizeLanguage(tag)
+	names, ok := lookupLanguage(tag)
+	if !ok && strings.Trim(tag, "abcdefghijklmnopqrstuvwxyz") != "" {
+		return ""
+	} else if !ok {
+		return tag
+	}
+	if r.Config.Engine.Unicode() {
+		return names[1]
+	}
+	return names[0]
+}
+
+// KnownLanguage reports whether tag is a BCP 47 language tag of a language the
+// renderer knows the babel and polyglossia names of, such as "es" or "pt-BR".
+func KnownLanguage(tag string) bool {
+	_, ok := lookupLanguage(normalizeLanguage(tag))
+	return ok
+}
+
+// normalizeLanguage returns tag lowercased and with hyphens as subtag separators.
+func normalizeLanguage(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+// lookupLanguage returns the language names of a normalized tag,
+// falling back to its primary language subtag.
+func lookupLanguage(tag string) (names [2]string, ok bool) {
+	names, ok = languageNames[tag]
+	if !ok {
+		primary, _, _ := strings.Cut(tag, "-")
+		names, ok = languageNames[primary]
+	}
+	return names, ok
+}
+
+// languages are the languages of a document.
+type languages struct {
+	main   string
+	others []string
+}
+
+// languages returns the main language of doc, set by Config.Language or the "lang"
+// metadata key, and the other languages set by lang attributes or used by right-to-left text.
+func (r *Renderer) languages(doc ast.Node, source []byte) (langs languages) {
+	tag := r.Config.Language
+	if d, ok := doc.(*ast.Document); ok {
+		if lang := metaStrings(d.Meta()["lang"]); len(lang) > 0 {
+			tag = lang[0]
+		}
+	}
+	if tag != "" {
+		langs.main = r.languageName(tag)
+		if langs.main == "" {
+			r.diagnose(nil, nil, SeverityWarning, CodeInvalidLanguage, "language "+strconv.Quote(tag)+" is not a language name, ignored")
+		}
+	}
+	add := func(lang string) {
+		if lang == langs.main {
+			return
+		}
+		for _, other := range langs.others {
+			if other == lang {
+				return
+			}
+		}
+		langs.others = append(langs.others, lang)
+	}
+	if doc != nil {
+		ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			if lang := r.nodeLanguage(n); entering && lang != "" {
+				add(lang)
+			}
+			return ast.WalkContinue, nil
+		})
+	}
+	if r.Config.Engine.Unicode() {
+		for _, lang := range rtlLanguages(source) {
+			add(lang)
+		}
+	}
+	if langs.main == "" && len(langs.others) > 0 {
+		langs.main = r.languageName("en")
+	}
+	return langs
+}
+
+// Package returns the package that sets up the languages, or an empty Package if none are set.
+func (langs languages) Package(engine Engine) Package {
+	switch {
+	case langs.main == "":
+		return Package{}
+	case engine.Unicode():
+		return Package{Name: "polyglossia"}
+	}
+	// The last option is babel's main language.
+	return Package{Name: "babel", Options: append(append([]string(nil), langs.others...), langs.main)}
+}
+
+// nodeLanguage returns the language name of the lang attribute of n,
+// or an empty string if n has none or it is not a language name.
+func (r *Renderer) nodeLanguage(n ast.Node) string {
+	tag, ok := langAttribute(n)
+	if !ok {
+		return ""
+	}
+	return r.languageName(tag)
+}
+
+// diagnoseLanguage reports a lang attribute of n that is not a language name.
+func (r *Renderer) diagnoseLanguage(source []byte, n ast.Node) {
+	if tag, ok := langAttribute(n); ok && r.languageName(tag) == "" {
+		r.diagnose(source, n, SeverityWarning, CodeInvalidLanguage, "lang attribute "+strconv.Quote(tag)+" is not a language name, ignored")
+	}
+}
+
+// langAttribute returns the lang attribute of n.
+func langAttribute(n ast.Node) (string, bool) {
+	v, ok := n.AttributeString("lang")
+	if !ok {
+		return "", false
+	}
+	switch v := v.(type) {
+	case []byte:
+		return string(v), true
+	case string:
+		return v, true
+	}
+	return "", true
+}
+
+// writeLanguageSetup writes the preamble lines that configure polyglossia
+// and the fonts of the right-to-left languages used.
+func (r *Renderer) writeLanguageSetup(w io.Writer, feats feature, langs languages) {
+	if feats&featRTL != 0 && !r.Config.Engine.Unicode() {
+		_, _ = io.WriteString(w, "\n% goldmark-latex: Hebrew and Arabic text require the xelatex or lualatex engine, left unwrapped.\n")
+		r.diagnose(nil, nil, SeverityWarning, CodeScriptEngine, "Hebrew and Arabic text require the xelatex or lualatex engine, left unwrapped")
+	}
+	if langs.main == "" || !r.Config.Engine.Unicode() {
+		return
+	}
+	_, _ = io.WriteString(w, "\n\\setdefaultlanguage{"+langs.main+"}\n")
+	for _, lang := range langs.others {
+		_, _ = io.WriteString(w, "\\setotherlanguage{"+lang+"}\n")
+	}
+	for _, lang := range append([]string{langs.main}, langs.others...) {
+		switch lang {
+		case "hebrew":
+			font := r.Config.Fonts.Hebrew
+			if font == "" {
+				font = "Noto Sans Hebrew"
+			}
+			_, _ = io.WriteString(w, "\\newfontfamily\\hebrewfont[Script=Hebrew]{"+font+"}\n")
+		case "arabic":
+			font := r.Config.Fonts.Arabic
+			if font == "" {
+				font = "Noto Naskh Arabic"
+			}
+			_, _ = io.WriteString(w, "\\newfontfamily\\arabicfont[Script=Arabic]{"+font+"}\n")
+		}
+	}
+}
+
+// inline wraps the render function of an inline node with behaviour common to all inlines.
+func (r *Renderer) inline(fn renderer.NodeRendererFunc) renderer.NodeRendererFunc {
+	return func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		lang := r.nodeLanguage(n)
+		if lang != "" && entering {
+			_, _ = w.WriteString("\\foreignlanguage{" + lang + "}{")
+		} else if entering {
+			r.diagnoseLanguage(source, n)
+		}
+		status, err := fn(w, source, n, entering)
+		if lang != "" && !entering {
+			_ = w.WriteByte('}')
+		}
+		return status, err
+	}
+}
@@ -5,39 +5,129 @@ import (
 	_ "embed"
 	"io"
 	"strconv"
+	"text/template"
 	"unicode"
 	"unicode/utf8"
 
 	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
 	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/renderer/html"
 	"github.com/yuin/goldmark/util"
+	"golang.org/x/text/unicode/norm"
 )
 
 // Config contains parameters for controlling LaTeX output of a Renderer.
 type Config struct {
+	// Document class used by the default preamble. Also selects the heading table:
+	// classes with chapters (report, book, memoir, scrreprt, scrbook) render H1 as \chapter.
+	// Defaults to article.
+	DocumentClass DocumentClass
+	// Adds the \part level on top of the heading table so that H1 becomes \part.
+	UsePart bool
 	// Increase heading levels: if the offset is 1, \section (1) becomes \subsection (2) etc.
 	// Negative offset is also valid.
-	// Resulting levels are clipped between 1 and 6.
+	// Resulting levels are clipped between the top level of the document class and \subparagraph.
 	HeadingLevelOffset int
 	// Removes section numbering.
 	NoHeadingNumbering bool
 	// Replace the default preamble by setting this to a non-nil byte slice.
 	// Should NOT end with \begin{document}, this is added automatically.
 	Preamble []byte
+	// Loads every package of the default preamble. By default only the
+	// packages needed by the constructs present in the document are loaded.
+	// When Preamble is set, packages needed by the document that are
+	// missing from it are added after it.
+	FullPreamble bool
+	// Document template, see ParseTemplate and TemplateData. The template
+	// must write {{.Body}}. If nil a default template is used which writes
+	// Preamble, or the default preamble if Preamble is nil.
+	Template *template.Template
+	// Typeset a table of contents after the title.
+	TOC bool
 	// If set renderer will render possibly unsafe elements, such as links and
 	// code block raw content.
 	Unsafe bool
 	// Declares all used unicode characters in the preamble
 	// and replaces them with the result of this function.
 	DeclareUnicode func(rune) (raw string, isReplaced bool)
+	// Replace non-ASCII characters inline with the result of DeclareUnicode
+	// instead of declaring them in the preamble. Always done for Fragment output,
+	// which has no preamble to declare them in.
+	InlineUnicode bool
+	// Renders only the document body, without preamble nor \begin{document} and \end{document}.
+	// Useful for \input-ing the result into an existing document.
+	// See Renderer.RequiredPackages for the packages the host document should load.
+	Fragment bool
+	// Prefix added to heading labels. Headings with an id attribute, i.e. when parsing
+	// with goldmark's parser.WithAutoHeadingID, are labeled \label{<LabelPrefix><id>}.
+	LabelPrefix string
+	// Resolves link destinations to heading labels. Links resolved to a label are
+	// rendered with \hyperref. If nil, destinations starting with # resolve to
+	// LabelPrefix followed by the fragment.
+	ResolveLink func(destination []byte) (label string, ok bool)
+	// Main language of the document as a BCP 47 tag such as "es" or "de",
+	// or a babel language name. Sets up babel, or polyglossia with Unicode
+	// engines, for hyphenation and localized names such as "Contents".
+	// The "lang" metadata key takes precedence.
+	// Nodes with a lang attribute are wrapped in \foreignlanguage or otherlanguage.
+	Language string
+	// TeX engine the output is meant to be compiled with. Defaults to PDFLaTeX.
+	// Unicode engines (XeLaTeX and LuaLaTeX) load fontspec and unicode-math
+	// instead of inputenc and fontenc and do not declare unicode characters.
+	Engine Engine
+	// Fonts used with Unicode engines. Empty fields keep the engine's default font.
+	Fonts Fonts
+	// Selects between document and beamer slide deck output.
+	Mode Mode
+	// Beamer theme used by the default beamer preamble, i.e: Madrid, metropolis.
+	BeamerTheme string
+	// Overrides the LaTeX written for each construct.
+	Macros Macros
+	// Functions called when entering and exiting nodes of a kind, which may
+	// add to or replace the LaTeX written for the node. See Hook.
+	Hooks map[ast.NodeKind]Hook
+	// Writes a %!md:LINE:COLUMN comment with the position in the markdown
+	// source before each block. See ExtractSourceMap.
+	LineMarkers bool
+	// Called for each problem found while rendering, such as markdown content
+	// dropped from the output. See DiagnosticCollector, which is safe for concurrent use.
+	OnDiagnostic func(Diagnostic)
+}
+
+// DocumentClass is a LaTeX document class name such as article or book.
+type DocumentClass string
+
+// Document classes known to the renderer.
+const (
+	ClassArticle  DocumentClass = "article"
+	ClassReport   DocumentClass = "report"
+	ClassBook     DocumentClass = "book"
+	ClassMemoir   DocumentClass = "memoir"
+	ClassScrartcl DocumentClass = "scrartcl"
+	ClassScrreprt DocumentClass = "scrreprt"
+	ClassScrbook  DocumentClass = "scrbook"
+)
+
+// HasChapters reports whether the document class defines the \chapter command.
+func (c DocumentClass) HasChapters() bool {
+	switch c {
+	case ClassReport, ClassBook, ClassMemoir, ClassScrreprt, ClassScrbook:
+		return true
+	}
+	return false
 }
 
 // SetLatexOption implements the Option interface.
 func (r Config) SetLatexOption(c *Config) { *c = r }
 
 // Renderer is a LaTeX renderer implementation for extending
-// goldmark to generate .tex files.
+// goldmark to generate .tex files. A Renderer keeps no state between renders
+// and may be used by multiple goroutines simultaneously, in which case
+// Config.OnDiagnostic and Config.Hooks must also be safe for concurrent use.
+// While a document is rendered the template output following its body is kept
+// as an attribute of the document node, so a document must not be rendered by
+// several goroutines at once.
 type Renderer struct {
 	Config Config
 }
@@ -57,6 +147,8 @@ type Option interface {
 //	r := renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(lr, 1000)))
 //	md := goldmark.New(goldmark.WithRenderer(r))
 //	md.Convert(markdown, LaTeXoutput)
+//
+// See Extension for a simpler setup.
 func NewRenderer(opts ...Option) renderer.NodeRenderer {
 	r := &Renderer{
 		Config: Config{},
@@ -68,108 +160,203 @@ func NewRenderer(opts ...Option) renderer.NodeRenderer {
 }
 
 // RegisterFuncs implements goldmark's renderer.NodeRenderer interface.
+// Node kinds the Renderer does not know are rendered by a fallback which
+// writes a comment naming the kind, so node renderers for them must be
+// registered with a higher priority (lower value) than the Renderer.
 func (r *Renderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg = hookRegisterer{reg: reg, r: r}
+	r.registerFallback(reg)
 	// blocks
 	reg.Register(ast.KindDocument, r.renderDocument)
-	reg.Register(ast.KindHeading, r.renderHeading)
-	reg.Register(ast.KindBlockquote, r.renderBlockquote)
-	reg.Register(ast.KindCodeBlock, r.renderCodeBlock)
-	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
-	reg.Register(ast.KindHTMLBlock, r.renderHTMLBlock)
-	reg.Register(ast.KindList, r.renderList)
+	reg.Register(ast.KindHeading, r.block(r.renderHeading))
+	reg.Register(ast.KindBlockquote, r.block(r.renderBlockquote))
+	reg.Register(ast.KindCodeBlock, r.block(r.renderCodeBlock))
+	reg.Register(ast.KindFencedCodeBlock, r.block(r.renderFencedCodeBlock))
+	reg.Register(ast.KindHTMLBlock, r.block(r.renderHTMLBlock))
+	reg.Register(ast.KindList, r.block(r.renderList))
 	reg.Register(ast.KindListItem, r.renderListItem)
-	reg.Register(ast.KindParagraph, r.renderParagraph)
+	reg.Register(ast.KindParagraph, r.block(r.renderParagraph))
 	reg.Register(ast.KindTextBlock, r.renderTextBlock)
-	reg.Register(ast.KindThematicBreak, r.renderThematicBreak)
+	reg.Register(ast.KindThematicBreak, r.block(r.renderThematicBreak))
 
 	// inlines
-	reg.Register(ast.KindAutoLink, r.renderAutoLink)
-	reg.Register(ast.KindCodeSpan, r.renderCodeSpan)
-	reg.Register(ast.KindEmphasis, r.renderEmphasis)
-	reg.Register(ast.KindImage, r.renderImage)
-	reg.Register(ast.KindLink, r.renderLink)
-	reg.Register(ast.KindRawHTML, r.renderRawHTML)
-	reg.Register(ast.KindText, r.renderText)
-	reg.Register(ast.KindString, r.renderString)
+	reg.Register(ast.KindAutoLink, r.inline(r.renderAutoLink))
+	reg.Register(ast.KindCodeSpan, r.inline(r.renderCodeSpan))
+	reg.Register(ast.KindEmphasis, r.inline(r.renderEmphasis))
+	reg.Register(ast.KindImage, r.inline(r.renderImage))
+	reg.Register(ast.KindLink, r.inline(r.renderLink))
+	reg.Register(ast.KindRawHTML, r.inline(r.renderRawHTML))
+	reg.Register(ast.KindText, r.inline(r.renderText))
+	reg.Register(ast.KindString, r.inline(r.renderString))
+
+	// extensions, see Extension.
+	reg.Register(east.KindTable, r.block(r.renderTable))
+	reg.Register(east.KindTableHeader, r.renderTableRow)
+	reg.Register(east.KindTableRow, r.renderTableRow)
+	reg.Register(east.KindTableCell, r.renderTableCell)
+	reg.Register(east.KindDefinitionList, r.block(r.renderDefinitionList))
+	reg.Register(east.KindDefinitionTerm, r.renderDefinitionTerm)
+	reg.Register(east.KindDefinitionDescription, r.renderDefinitionDescription)
+	reg.Register(east.KindFootnoteList, r.block(r.renderFootnoteList))
+	reg.Register(east.KindFootnote, r.renderFootnote)
+	reg.Register(KindMathBlock, r.block(r.renderMathBlock))
+	reg.Register(east.KindStrikethrough, r.inline(r.renderStrikethrough))
+	reg.Register(east.KindTaskCheckBox, r.inline(r.renderTaskCheckBox))
+	reg.Register(east.KindFootnoteLink, r.inline(r.renderFootnoteLink))
+	reg.Register(east.KindFootnoteBacklink, r.renderFootnoteBacklink)
+	reg.Register(KindMath, r.inline(r.renderMath))
 }
 
 func (r *Renderer) renderDocument(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	if !entering {
-		// End of program.
-		w.WriteString("\n\\end{document}\n")
-		return ast.WalkStop, nil
+		if r.Config.Mode == Beamer && r.frameOpen(node.LastChild()) {
+			_, _ = w.Write(frameEnd)
+		}
+		r.writeCJKEnd(w, source)
 	}
-
-	if r.Config.Preamble == nil {
-		w.Write(defaultPreamble)
-	} else {
-		w.Write(r.Config.Preamble)
+	if r.Config.Fragment {
+		if entering {
+			r.writeCJKStart(w, source)
+		}
+		return ast.WalkContinue, nil
 	}
-	if r.Config.DeclareUnicode != nil {
-		_ = w.WriteByte('\n')
-		const unicodeDecl = "\\DeclareUnicodeCharacter{"
-		const zeropad = "00"
-		declared := make(map[rune]struct{})
-		n := len(source)
-		i := 0
-		for i < n {
-			char, lchar := utf8.DecodeRune(source[i:])
-			i += lchar
-			if lchar == 1 {
-				continue // ASCII character.
-			}
-			if _, ok := declared[char]; ok {
-				continue
-			}
-			declared[char] = struct{}{}
-			replace, ok := r.Config.DeclareUnicode(char)
-			if !ok {
-				continue
-			}
-			_, _ = w.WriteString(unicodeDecl)
-			num := strconv.FormatUint(uint64(char), 16)
-			_, _ = w.WriteString(zeropad[:2-(len(num)-2)])
-			_, _ = w.WriteString(num)
-			_, _ = w.WriteString("}{")
-			_, _ = w.WriteString(replace)
-			_, _ = w.WriteString("}\n")
+	if entering {
+		head, tail, err := r.documentTemplate(node, source)
+		if err != nil {
+			return ast.WalkStop, err
 		}
+		node.SetAttribute(tailAttribute, tail)
+		_, _ = w.WriteString(head)
+		r.writeCJKStart(w, source)
+		return ast.WalkContinue, nil
 	}
-	w.WriteString("\n\\begin{document}\n")
-	return ast.WalkContinue, nil
+	// End of program.
+	if tail, ok := node.Attribute(tailAttribute); ok && tail != nil {
+		node.SetAttribute(tailAttribute, nil)
+		_, _ = w.WriteString(tail.(string))
+	}
+	return ast.WalkStop, nil
 }
 
-// Do not modify.
-//
-//go:embed defaultPreamble.tex
-var defaultPreamble []byte
+// tailAttribute is the document attribute holding the template output
+// written after the body.
+var tailAttribute = []byte("latexTemplateTail")
+
+// writeUnicodeDeclarations declares every non-ASCII character of source
+// replaced by Config.DeclareUnicode.
+func (r *Renderer) writeUnicodeDeclarations(w io.Writer, source []byte) {
+	if r.inlineUnicode() {
+		return
+	}
+	_, _ = io.WriteString(w, "\n")
+	source = norm.NFC.Bytes(source)
+	const unicodeDecl = "\\DeclareUnicodeCharacter{"
+	const zeropad = "00"
+	declared := make(map[rune]struct{})
+	n := len(source)
+	i := 0
+	for i < n {
+		char, lchar := utf8.DecodeRune(source[i:])
+		i += lchar
+		if lchar == 1 {
+			continue // ASCII character.
+		}
+		if _, ok := declared[char]; ok {
+			continue
+		}
+		declared[char] = struct{}{}
+		replace, ok := r.Config.DeclareUnicode(char)
+		if !ok {
+			continue
+		}
+		_, _ = io.WriteString(w, unicodeDecl)
+		num := strconv.FormatUint(uint64(char), 16)
+		_, _ = io.WriteString(w, zeropad[:2-(len(num)-2)])
+		_, _ = io.WriteString(w, num)
+		_, _ = io.WriteString(w, "}{")
+		_, _ = io.WriteString(w, replace)
+		_, _ = io.WriteString(w, "}\n")
+	}
+}
 
 // DefaultPreamble returns a copy of the default preamble provided by goldmark-latex.
 // It does not include \begin{document} text within, as expected by Config.Preamble.
 func DefaultPreamble() []byte {
-	cp := make([]byte, len(defaultPreamble))
-	copy(cp, defaultPreamble)
-	return cp
+	var b bytes.Buffer
+	err := defaultTemplate.Execute(&b, (&Renderer{Config: Config{FullPreamble: true}}).TemplateData(nil, nil))
+	if err != nil {
+		panic(err)
+	}
+	preamble, _, _ := bytes.Cut(b.Bytes(), []byte("\\begin{document}"))
+	return preamble
+}
+
+// headingIndex returns the index into headingTable for a markdown heading level.
+func (r *Renderer) headingIndex(level int) int {
+	top := headingSection
+	if r.Config.DocumentClass.HasChapters() {
+		top = headingChapter
+	}
+	// depth is the level below the top of the document, 0 being the top level.
+	depth := r.Config.HeadingLevelOffset + level - 1
+	if r.Config.UsePart {
+		// \part is on top of the class levels, also for classes without \chapter.
+		if depth <= 0 {
+			return headingPart
+		}
+		depth--
+	}
+	return max(top, min(len(headingTable)-1, top+depth))
 }
 
 func (r *Renderer) renderHeading(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	n := node.(*ast.Heading)
-	if entering {
-		headingLevel := max(0, min(6, r.Config.HeadingLevelOffset+n.Level-1))
+	if r.Config.Mode == Beamer {
+		return r.renderBeamerHeading(w, source, n, entering)
+	}
+	macro := r.Config.Macros.Heading[max(1, min(6, n.Level))-1]
+	if entering && !macro.IsZero() {
+		_ = w.WriteByte('\n')
+		_, _ = w.WriteString(macro.Open)
+	} else if entering {
+		headingLevel := r.headingIndex(n.Level)
 		start := headingTable[headingLevel][bool2int(r.Config.NoHeadingNumbering)]
 		_ = w.WriteByte('\n')
 		_, _ = w.Write(start)
-		if headingLevel >= 5 {
+		if headingLevel >= headingBold {
 			// _, _ = w.Write(softBreak)
 			w.WriteByte('\n')
 		}
 	} else {
-		_ = w.WriteByte('}')
+		if macro.IsZero() {
+			_ = w.WriteByte('}')
+		} else {
+			_, _ = w.WriteString(macro.Close)
+		}
+		if id, ok := n.AttributeString("id"); ok {
+			if id, ok := id.([]byte); ok {
+				_, _ = w.WriteString("\\label{")
+				writeLabel(w, r.Config.LabelPrefix)
+				writeLabel(w, string(id))
+				_ = w.WriteByte('}')
+			}
+		}
 	}
 	return ast.WalkContinue, nil
 }
 
 func (r *Renderer) renderBlockquote(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if r.Config.Mode == Beamer && isNote(source, n) {
+		if entering {
+			_, _ = w.Write(noteStart)
+		} else {
+			_, _ = w.WriteString("}\n")
+		}
+		return ast.WalkContinue, nil
+	}
+	if writeMacro(w, r.Config.Macros.Blockquote, entering) {
+		return ast.WalkContinue, nil
+	}
 	if entering {
 		_, _ = w.Write(blockQuoteStart)
 	} else {
@@ -180,10 +367,12 @@ func (r *Renderer) renderBlockquote(w util.BufWriter, source []byte, n ast.Node,
 
 func (r *Renderer) renderCodeBlock(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
 	if entering {
-		_, _ = w.Write(blockCodeStart)
+		if !writeMacro(w, r.Config.Macros.CodeBlock, entering) {
+			_, _ = w.Write(blockCodeStart)
+		}
 		_ = w.WriteByte('\n')
 		r.writeRawLines(w, source, n)
-	} else {
+	} else if !writeMacro(w, r.Config.Macros.CodeBlock, entering) {
 		_, _ = w.Write(blockCodeEnd)
 	}
 	return ast.WalkContinue, nil
@@ -191,11 +380,21 @@ func (r *Renderer) renderCodeBlock(w util.BufWriter, source []byte, n ast.Node,
 
 func (r *Renderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	n := node.(*ast.FencedCodeBlock)
+	if writeMacro(w, r.Config.Macros.CodeBlock, entering) {
+		if entering {
+			_ = w.WriteByte('\n')
+			r.writeRawLines(w, source, n)
+		}
+		return ast.WalkContinue, nil
+	}
 	if entering {
 		_, _ = w.Write(blockCodeStart)
 		language := n.Language(source)
 		language = language[:min(10, len(language))]
 		_, supported := supportedLang[string(language)]
+		if language != nil && !supported {
+			r.diagnose(source, n, SeverityWarning, CodeUnknownLanguage, "code block language "+strconv.Quote(string(n.Language(source)))+" not supported by listings, rendered without highlighting")
+		}
 		if language != nil && supported {
 			_, _ = w.WriteString("[language=")
 			escapeLaTeX(w, language)
@@ -211,14 +410,22 @@ func (r *Renderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node a
 
 func (r *Renderer) renderHTMLBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	w.WriteString("\n% goldmark-latex: HTML block rendering unsupported, skipped\n")
+	if entering {
+		r.diagnose(source, node, SeverityWarning, CodeHTMLBlock, "HTML block skipped")
+	}
 	return ast.WalkSkipChildren, nil
 }
 
 func (r *Renderer) renderList(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	n := node.(*ast.List)
 	tag := "itemize"
+	macro := r.Config.Macros.List
 	if n.IsOrdered() {
 		tag = "enumerate"
+		macro = r.Config.Macros.OrderedList
+	}
+	if writeMacro(w, macro, entering) {
+		return ast.WalkContinue, nil
 	}
 	if entering {
 		_, _ = w.WriteString("\n\\begin{")
@@ -233,6 +440,9 @@ func (r *Renderer) renderList(w util.BufWriter, source []byte, node ast.Node, en
 }
 
 func (r *Renderer) renderListItem(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if writeMacro(w, r.Config.Macros.ListItem, entering) {
+		return ast.WalkContinue, nil
+	}
 	if entering {
 		_, _ = w.Write(itemCommand)
 		fc := n.FirstChild()
@@ -250,11 +460,11 @@ func (r *Renderer) renderListItem(w util.BufWriter, source []byte, n ast.Node, e
 func (r *Renderer) renderParagraph(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
 	if !entering {
 		parent := n.Parent()
-		pkind := parent.Kind()
-		if pkind != ast.KindList && pkind != ast.KindListItem {
-			_, _ = w.Write(hardBreak)
-		} else {
+		switch parent.Kind() {
+		case ast.KindList, ast.KindListItem, east.KindDefinitionDescription, east.KindFootnote:
 			_, _ = w.WriteString("\n\n")
+		default:
+			_, _ = w.Write(hardBreak)
 		}
 	}
 	return ast.WalkContinue, nil
@@ -270,6 +480,12 @@ func (r *Renderer) renderTextBlock(w util.BufWriter, source []byte, n ast.Node,
 }
 
 func (r *Renderer) renderThematicBreak(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if r.Config.Mode == Beamer {
+		return r.renderBeamerThematicBreak(w, n, entering)
+	}
+	if writeMacro(w, r.Config.Macros.ThematicBreak, entering) {
+		return ast.WalkContinue, nil
+	}
 	if entering {
 		_, _ = w.Write(hruleCommand)
 		_ = w.WriteByte('\n')
@@ -290,7 +506,7 @@ func (r *Renderer) renderAutoLink(w util.BufWriter, source []byte, node ast.Node
 	}
 	escLink(w, url)
 	_, _ = w.WriteString("}{")
-	escapeLaTeX(w, label)
+	r.escapeText(w, label)
 	_ = w.WriteByte('}')
 	return ast.WalkContinue, nil
 }
@@ -313,27 +529,45 @@ func haslowerprefix(a, b []byte) bool {
 }
 
 func (r *Renderer) renderCodeSpan(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	macro := r.Config.Macros.CodeSpan
 	if !entering {
-		_ = w.WriteByte('}')
+		if !writeMacro(w, macro, entering) {
+			_ = w.WriteByte('}')
+		}
 		return ast.WalkContinue, nil
 	}
 
 	// Render all children within code span. Should all be Text kind.
-	_, _ = w.Write(codeSpanStart)
+	if !writeMacro(w, macro, entering) {
+		_, _ = w.Write(codeSpanStart)
+	}
 	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
 		segment := c.(*ast.Text).Segment
 		value := segment.Value(source)
 		if bytes.HasSuffix(value, []byte("\n")) {
-			escapeLaTeX(w, value[:len(value)-1])
+			r.escapeText(w, value[:len(value)-1])
 			_ = w.WriteByte(' ')
 		} else {
-			escapeLaTeX(w, value)
+			r.escapeText(w, value)
 		}
 	}
 	return ast.WalkSkipChildren, nil // Skip all of them after rendering.
 }
 
 func (r *Renderer) renderEmphasis(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	level := node.(*ast.Emphasis).Level
+	if !r.Config.Macros.Emphasis[2].IsZero() {
+		// ***a*** is parsed as level 1 and 2 emphasis nested, which
+		// the level 3 macro replaces as a whole.
+		if strongEmphasis(node) {
+			level = 3
+		} else if strongEmphasis(node.Parent()) {
+			return ast.WalkContinue, nil
+		}
+	}
+	if writeMacro(w, r.Config.Macros.Emphasis[max(1, min(3, level))-1], entering) {
+		return ast.WalkContinue, nil
+	}
 	if entering {
 		const (
 			emph  = "\\textit{"
@@ -354,8 +588,33 @@ func (r *Renderer) renderEmphasis(w util.BufWriter, source []byte, node ast.Node
 	return ast.WalkContinue, nil
 }
 
+// strongEmphasis reports whether n is the outer node of a level 1 and a level 2
+// emphasis nested with nothing else in between, i.e. ***a***.
+func strongEmphasis(n ast.Node) bool {
+	outer, ok := n.(*ast.Emphasis)
+	if !ok {
+		return false
+	}
+	inner, ok := outer.FirstChild().(*ast.Emphasis)
+	if !ok || outer.ChildCount() != 1 || inner.Level+outer.Level != 3 {
+		return false
+	}
+	// With three emphasis nested the middle one is the inner node of a pair.
+	return !strongEmphasis(outer.Parent())
+}
+
 func (r *Renderer) renderLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	n := node.(*ast.Link)
+	if label, ok := r.linkLabel(n.Destination); ok {
+		if entering {
+			_, _ = w.WriteString(`\hyperref[`)
+			writeLabel(w, label)
+			_, _ = w.WriteString("]{")
+		} else {
+			_ = w.WriteByte('}')
+		}
+		return ast.WalkContinue, nil
+	}
 	if entering {
 		_, _ = w.WriteString(`\href{`)
 		if r.Config.Unsafe || !html.IsDangerousURL(n.Destination) {
@@ -369,15 +628,41 @@ func (r *Renderer) renderLink(w util.BufWriter, source []byte, node ast.Node, en
 	return ast.WalkContinue, nil
 }
 
+// linkLabel returns the label a link destination refers to.
+func (r *Renderer) linkLabel(dest []byte) (string, bool) {
+	if r.Config.ResolveLink != nil {
+		return r.Config.ResolveLink(dest)
+	}
+	if len(dest) > 1 && dest[0] == '#' {
+		return r.Config.LabelPrefix + string(dest[1:]), true
+	}
+	return "", false
+}
+
+// writeLabel writes a label name, omitting characters that could break \label and \ref.
+func writeLabel(w util.BufWriter, label string) {
+	for _, c := range label {
+		if c < utf8.RuneSelf && labelChar[c] {
+			_ = w.WriteByte(byte(c))
+		}
+	}
+}
+
 func (r *Renderer) renderImage(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	// No image rendering implemented yet.
 	w.WriteString("\n% goldmark-latex: image rendering unsupported as of yet\n")
+	if entering {
+		r.diagnose(source, node, SeverityWarning, CodeImage, "image "+strconv.Quote(string(node.(*ast.Image).Destination))+" skipped")
+	}
 	return ast.WalkSkipChildren, nil
 }
 
 func (r *Renderer) renderRawHTML(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	// No rawHTML rendering supported
 	w.WriteString("\n% goldmark-latex: raw HTML rendering unsupported\n")
+	if entering {
+		r.diagnose(source, node, SeverityWarning, CodeRawHTML, "inline HTML skipped")
+	}
 	return ast.WalkSkipChildren, nil
 }
 
@@ -387,11 +672,12 @@ func (r *Renderer) renderText(w util.BufWriter, source []byte, node ast.Node, en
 	}
 	n := node.(*ast.Text)
 	segment := n.Segment.Value(source)
+	segment = segment[r.notePrefixLen(source, n):]
 	if n.IsRaw() {
 		w.Write(segment)
 		// r.Writer.RawWrite(w, segment.Value(source))
 	} else {
-		escapeLaTeX(w, segment)
+		r.escapeText(w, segment)
 		if n.HardLineBreak() {
 			_, _ = w.Write(hardBreak)
 		} else if n.SoftLineBreak() {
@@ -410,7 +696,7 @@ func (r *Renderer) renderString(w util.BufWriter, source []byte, node ast.Node,
 	if n.IsCode() || n.IsRaw() {
 		_, _ = w.Write(n.Value)
 	} else {
-		escapeLaTeX(w, n.Value)
+		r.escapeText(w, n.Value)
 	}
 	return ast.WalkContinue, nil
 }
@@ -419,7 +705,7 @@ func (r *Renderer) writeLines(w util.BufWriter, source []byte, n ast.Node) {
 	l := n.Lines().Len()
 	for i := 0; i < l; i++ {
 		line := n.Lines().At(i)
-		escapeLaTeX(w, line.Value(source))
+		r.escapeText(w, line.Value(source))
 	}
 }
 
@@ -433,6 +719,11 @@ func (r *Renderer) writeRawLines(w util.BufWriter, source []byte, n ast.Node) {
 		} else {
 			_, _ = w.WriteString("% goldmark-latex: Skipped following line due to possibly unsafe content:\n%")
 			_, _ = w.Write(text)
+			d := Diagnostic{Severity: SeverityWarning, Kind: n.Kind(), Code: CodeUnsafeLine, Message: "possibly unsafe code line commented out"}
+			if r.Config.OnDiagnostic != nil {
+				d.Line, d.Column = position(source, line.Start)
+				r.Config.OnDiagnostic(d)
+			}
 		}
 	}
 }
@@ -475,9 +766,9 @@ var (
 	itemCommand  = []byte("\\item~ ")
 	tableStart   = []byte("\n\\begin{table}\n")
 	tableEnd     = []byte("\n\\end{table}\n")
-	headingTable = [6][2][]byte{
-		// {[]byte("\\part{"), []byte("\\part*{")},
-		// {[]byte("\\chapter{"), []byte("\\chapter*{")},
+	headingTable = [8][2][]byte{
+		{[]byte("\\part{"), []byte("\\part*{")},
+		{[]byte("\\chapter{"), []byte("\\chapter*{")},
 		{[]byte("\\section{"), []byte("\\section*{")},
 		{[]byte("\\subsection{"), []byte("\\subsection*{")},
 		{[]byte("\\subsubsection{"), []byte("\\subsubsection*{")},
@@ -487,6 +778,22 @@ var (
 	}
 )
 
+// Indices of notable levels in headingTable.
+const (
+	headingPart    = 0
+	headingChapter = 1
+	headingSection = 2
+	headingBold    = 7
+)
+
+// labelChar contains the ASCII characters allowed in labels.
+var labelChar = func() (allowed [utf8.RuneSelf]bool) {
+	for _, c := range "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-:._/" {
+		allowed[c] = true
+	}
+	return allowed
+}()
+
 var escapeTable = [256][]byte{
 	'\\': []byte("\\textbackslash~"),
 	'~':  []byte("\\textasciitilde~"),
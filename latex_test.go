@@ -3,13 +3,19 @@ package latex_test
 import (
 	"bytes"
 	_ "embed"
+	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	latex "github.com/soypat/goldmark-latex"
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
 	"github.com/yuin/goldmark/util"
 )
 
@@ -30,9 +36,14 @@ func TestRenderer(t *testing.T) {
 }
 
 func render(t *testing.T, markdown io.Reader) *bytes.Buffer {
+	preamble, err := latex.ParsePreamble(latex.DefaultPreamble())
+	if err != nil {
+		t.Fatal(err)
+	}
+	preamble.UsePackage("MnSymbol") // add star symbols to preamble.
 	r := renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(latex.NewRenderer(latex.Config{
-		NoHeadingNumbering: true,                                                                     // No heading numbers
-		Preamble:           append(latex.DefaultPreamble(), []byte("\n\\usepackage{MnSymbol}\n")...), // add star symbols to preamble.
+		NoHeadingNumbering: true, // No heading numbers
+		Preamble:           preamble.Bytes(),
 		DeclareUnicode: func(r rune) (raw string, isReplaced bool) {
 			switch r {
 			case '★':
@@ -45,7 +56,7 @@ func render(t *testing.T, markdown io.Reader) *bytes.Buffer {
 	}), 1000)))
 	md := goldmark.New(goldmark.WithRenderer(r))
 	var output, input bytes.Buffer
-	_, err := io.Copy(&input, markdown)
+	_, err = io.Copy(&input, markdown)
 	if err != nil {
 		t.Error(err)
 	}
@@ -55,3 +66,814 @@ func render(t *testing.T, markdown io.Reader) *bytes.Buffer {
 	}
 	return &output
 }
+
+func convert(t *testing.T, cfg latex.Config, markdown string) string {
+	t.Helper()
+	r := renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(latex.NewRenderer(cfg), 1000)))
+	md := goldmark.New(goldmark.WithRenderer(r))
+	var output bytes.Buffer
+	err := md.Convert([]byte(markdown), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output.String()
+}
+
+func TestDocumentClass(t *testing.T) {
+	const md = "# One\n\n## Two\n"
+	for _, test := range []struct {
+		cfg   latex.Config
+		class string
+		h1    string
+		h2    string
+	}{
+		{cfg: latex.Config{}, class: "article", h1: "\\section{One}", h2: "\\subsection{Two}"},
+		{cfg: latex.Config{DocumentClass: latex.ClassBook}, class: "book", h1: "\\chapter{One}", h2: "\\section{Two}"},
+		{cfg: latex.Config{DocumentClass: latex.ClassReport, UsePart: true}, class: "report", h1: "\\part{One}", h2: "\\chapter{Two}"},
+		{cfg: latex.Config{DocumentClass: latex.ClassScrbook, HeadingLevelOffset: -3}, class: "scrbook", h1: "\\chapter{One}", h2: "\\chapter{Two}"},
+		{cfg: latex.Config{UsePart: true}, class: "article", h1: "\\part{One}", h2: "\\section{Two}"},
+		{cfg: latex.Config{HeadingLevelOffset: 1}, class: "article", h1: "\\subsection{One}", h2: "\\subsubsection{Two}"},
+	} {
+		got := convert(t, test.cfg, md)
+		if !strings.Contains(got, "\\documentclass{"+test.class+"}") {
+			t.Errorf("%+v: missing document class %q", test.cfg, test.class)
+		}
+		if !strings.Contains(got, test.h1) || !strings.Contains(got, test.h2) {
+			t.Errorf("%+v: want %q and %q in output:\n%s", test.cfg, test.h1, test.h2, got)
+		}
+	}
+}
+
+func TestBeamer(t *testing.T) {
+	const md = "Intro\n\n# Section\n\n## First slide\n\n```go\nfunc main() {}\n```\n\n> Note: mention the demo.\n\n---\n\nUntitled slide\n"
+	got := convert(t, latex.Config{Mode: latex.Beamer, BeamerTheme: "Madrid"}, md)
+	for _, want := range []string{
+		"\\documentclass{beamer}",
+		"\\usetheme{Madrid}",
+		"\\begin{frame}\nIntro",
+		"\\end{frame}\n\n\\section{Section}",
+		"\\begin{frame}[fragile]{First slide}",
+		"\\note{mention the demo.",
+		"\\end{frame}\n\n\\begin{frame}\nUntitled slide",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output:\n%s", want, got)
+		}
+	}
+	if begins, ends := strings.Count(got, "\\begin{frame}"), strings.Count(got, "\\end{frame}"); begins != 3 || ends != 3 {
+		t.Errorf("got %d frame starts and %d frame ends, want 3", begins, ends)
+	}
+}
+
+func TestFragment(t *testing.T) {
+	const md = "# Title\n\n> quoted [link](https://example.com)\n"
+	cfg := latex.Config{Fragment: true}
+	got := convert(t, cfg, md)
+	for _, notwant := range []string{"\\documentclass", "\\begin{document}", "\\end{document}"} {
+		if strings.Contains(got, notwant) {
+			t.Errorf("fragment contains %q:\n%s", notwant, got)
+		}
+	}
+	if !strings.Contains(got, "\\section{Title}") {
+		t.Errorf("fragment missing body:\n%s", got)
+	}
+	doc := goldmark.New().Parser().Parse(text.NewReader([]byte(md)))
+	lr := latex.NewRenderer(cfg).(*latex.Renderer)
+	pkgs := lr.RequiredPackages(doc, []byte(md))
+	var names []string
+	for _, pkg := range pkgs {
+		names = append(names, pkg.Name)
+	}
+	if strings.Join(names, ",") != "hyperref,framed" {
+		t.Errorf("got required packages %v, want hyperref and framed", names)
+	}
+}
+
+func TestHeadingLabels(t *testing.T) {
+	const md = "# Getting started\n\nSee [usage](#usage) and [the guide](guide.md#intro).\n\n## Usage\n"
+	r := renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(latex.NewRenderer(latex.Config{
+		Fragment:    true,
+		LabelPrefix: "ch1:",
+	}), 1000)))
+	md2 := goldmark.New(goldmark.WithRenderer(r), goldmark.WithParserOptions(parser.WithAutoHeadingID()))
+	var output bytes.Buffer
+	err := md2.Convert([]byte(md), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := output.String()
+	for _, want := range []string{
+		"\\section{Getting started}\\label{ch1:getting-started}",
+		"\\subsection{Usage}\\label{ch1:usage}",
+		"\\hyperref[ch1:usage]{usage}",
+		"\\href{guide.md\\#intro}{the guide}",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output:\n%s", want, got)
+		}
+	}
+}
+
+func TestExtensionHeadingLabels(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(latex.Extension(latex.Config{Fragment: true})))
+	var output bytes.Buffer
+	err := md.Convert([]byte("See [usage](#usage).\n\n## Usage\n"), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := output.String()
+	if !strings.Contains(got, "\\hyperref[usage]{usage}") || !strings.Contains(got, "\\label{usage}") {
+		t.Errorf("fragment link and heading label do not match:\n%s", got)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := latex.ParseTemplate(`\documentclass{ {{- .DocumentClass}}}
+\begin{document}
+\begin{titlepage}{{.Title}} by {{join .Authors ", "}} ({{escape .Meta.version}}){{if .TOC}}\tableofcontents{{end}}\end{titlepage}
+{{.Body}}
+\closing
+\end{document}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const md = "# Body heading\n"
+	lr := latex.NewRenderer(latex.Config{Template: tmpl, TOC: true})
+	r := renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(lr, 1000)))
+	doc := goldmark.New().Parser().Parse(text.NewReader([]byte(md)))
+	doc.(*ast.Document).SetMeta(map[string]interface{}{
+		"title":   "R&D notes",
+		"authors": []interface{}{"Ada", "Grace"},
+		"version": "v1_0",
+	})
+	var output bytes.Buffer
+	err = r.Render(&output, []byte(md), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `\documentclass{article}
+\begin{document}
+\begin{titlepage}R\&D notes by Ada, Grace (v1\_0)\tableofcontents\end{titlepage}
+
+\section{Body heading}
+\closing
+\end{document}
+`
+	if got := output.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The template is executed once per render.
+	var executions counter
+	tmpl, _ = latex.ParseTemplate(`{{.Meta.executions}}{{.Body}}`)
+	doc.(*ast.Document).SetMeta(map[string]interface{}{"executions": &executions})
+	err = renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(latex.NewRenderer(latex.Config{Template: tmpl}), 1000))).Render(&output, []byte(md), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if executions != 1 {
+		t.Errorf("template executed %d times, want once", executions)
+	}
+
+	// Templates must write the body.
+	tmpl, _ = latex.ParseTemplate(`{{.Title}}`)
+	err = renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(latex.NewRenderer(latex.Config{Template: tmpl}), 1000))).Render(&output, []byte(md), doc)
+	if err == nil {
+		t.Error("expected error for template without body")
+	}
+}
+
+// counter counts the times it is formatted.
+type counter int
+
+func (c *counter) String() string {
+	*c++
+	return ""
+}
+
+func TestDefaultTemplateMetadata(t *testing.T) {
+	const md = "Text\n"
+	doc := goldmark.New().Parser().Parse(text.NewReader([]byte(md)))
+	doc.(*ast.Document).SetMeta(map[string]interface{}{"title": "Notes", "author": "Ada", "toc": true})
+	r := renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(latex.NewRenderer(latex.Config{FullPreamble: true}), 1000)))
+	var output bytes.Buffer
+	err := r.Render(&output, []byte(md), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := output.String()
+	for _, want := range []string{"\\title{Notes}", "\\author{Ada}", "\\maketitle", "\\tableofcontents", "\\usepackage[dvipsnames]{xcolor}"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output:\n%s", want, got)
+		}
+	}
+	if !bytes.HasPrefix([]byte(got), latex.DefaultPreamble()) {
+		t.Error("output does not start with default preamble")
+	}
+	if want := `{á}{{\'a}}1`; !bytes.Contains(latex.DefaultPreamble(), []byte(want)) {
+		t.Errorf("default preamble listings literate option does not contain %s", want)
+	}
+}
+
+func TestPreambleBuilder(t *testing.T) {
+	pb := latex.NewPreambleBuilder(latex.ClassReport, "a4paper", "10pt")
+	pb.FontSize("12pt")
+	pb.UsePackage("xcolor", "dvipsnames")
+	pb.UsePackage("geometry", "margin=1in")
+	if err := pb.UsePackage("xcolor", "dvipsnames", "table"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pb.UsePackage("geometry", "margin=2cm"); err == nil {
+		t.Error("expected option clash error")
+	}
+	pb.Geometry("margin=2cm")
+	pb.Hypersetup("linkcolor", "blue")
+	pb.Hypersetup("colorlinks", "")
+	pb.Hypersetup("linkcolor", "black")
+	pb.UsePackage("framed")
+	pb.RemovePackage("framed")
+	pb.AddRaw(`\parindent=0pt`)
+	const want = `\documentclass[a4paper,12pt]{report}
+
+\usepackage[dvipsnames,table]{xcolor}
+\usepackage[margin=2cm]{geometry}
+\usepackage{hyperref}
+
+\hypersetup{%
+  linkcolor=black,%
+  colorlinks}
+
+\parindent=0pt
+`
+	if got := pb.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	pb, err := latex.ParsePreamble(latex.DefaultPreamble())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb.RemovePackage("listings")
+	pb.Hypersetup("pdfauthor", "{Ada, Grace}")
+	got := pb.String()
+	if strings.Contains(got, "{listings}") || !strings.Contains(got, "\\usepackage[margin=1in]{geometry}") ||
+		!strings.Contains(got, "pdfauthor={Ada, Grace}}") || !strings.Contains(got, "\\lstset{") {
+		t.Errorf("unexpected preamble parsed from default:\n%s", got)
+	}
+	reparsed, err := latex.ParsePreamble(pb.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reparsed.String() != got {
+		t.Errorf("rendering is not stable after parsing:\n%s", reparsed)
+	}
+	reparsed.RemovePackage("hyperref")
+	if got := reparsed.String(); strings.Contains(got, "hyperref") || strings.Contains(got, "\\hypersetup") {
+		t.Errorf("hyperref options left after removing hyperref:\n%s", got)
+	}
+
+	// Raw LaTeX keeps its place relative to the parsed commands.
+	pb, err = latex.ParsePreamble([]byte(`\PassOptionsToPackage{hyphens}{url}
+\documentclass{article}
+\usepackage{hyperref}
+\renewcommand{\familydefault}{\sfdefault}
+\usepackage{helvet}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb.UsePackage("xcolor")
+	const wantOrder = `\PassOptionsToPackage{hyphens}{url}
+
+\documentclass{article}
+
+\usepackage{hyperref}
+
+\renewcommand{\familydefault}{\sfdefault}
+
+\usepackage{helvet}
+\usepackage{xcolor}
+`
+	if got := pb.String(); got != wantOrder {
+		t.Errorf("got:\n%s\nwant:\n%s", got, wantOrder)
+	}
+}
+
+func TestFeaturePreamble(t *testing.T) {
+	got := convert(t, latex.Config{}, "Plain text.\n")
+	for _, notwant := range []string{"{listings}", "{hyperref}", "{framed}", "{xcolor}", "\\lstset", "\\hypersetup"} {
+		if strings.Contains(got, notwant) {
+			t.Errorf("plain document preamble contains %q:\n%s", notwant, got)
+		}
+	}
+	got = convert(t, latex.Config{}, "```go\nfunc main() {}\n```\n\n<https://example.com>\n")
+	for _, want := range []string{"\\usepackage{listings}", "\\usepackage[dvipsnames]{xcolor}", "\\lstset{", "\\usepackage{hyperref}", "\\hypersetup{"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in preamble:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "{framed}") {
+		t.Errorf("unused framed package loaded:\n%s", got)
+	}
+
+	// Custom preambles get missing packages appended.
+	got = convert(t, latex.Config{Preamble: []byte("\\documentclass{article}\n\\usepackage{hyperref}\n")}, "> quote [link](https://example.com)\n")
+	if !strings.Contains(got, "% goldmark-latex: package framed is used by the document but missing from the preamble") ||
+		!strings.Contains(got, "\\usepackage{framed}") || strings.Count(got, "{hyperref}") != 1 {
+		t.Errorf("missing packages not added to custom preamble:\n%s", got)
+	}
+}
+
+func TestEngine(t *testing.T) {
+	const md = "Grüße ★\n"
+	declare := func(r rune) (string, bool) { return `$\star$`, r == '★' }
+	got := convert(t, latex.Config{DeclareUnicode: declare}, md)
+	for _, want := range []string{"\\usepackage[utf8]{inputenc}", "\\usepackage[T1]{fontenc}", "\\DeclareUnicodeCharacter{2605}", "{helvet}"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("pdflatex: missing %q in output:\n%s", want, got)
+		}
+	}
+	for _, engine := range []latex.Engine{latex.XeLaTeX, latex.LuaLaTeX} {
+		got = convert(t, latex.Config{
+			Engine:         engine,
+			Fonts:          latex.Fonts{Main: "TeX Gyre Pagella", Mono: "Fira Mono"},
+			DeclareUnicode: declare,
+		}, md)
+		for _, want := range []string{"\\usepackage{fontspec}", "\\usepackage{unicode-math}", "\\setmainfont{TeX Gyre Pagella}", "\\setmonofont{Fira Mono}", "Grüße ★"} {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: missing %q in output:\n%s", engine, want, got)
+			}
+		}
+		for _, notwant := range []string{"inputenc", "fontenc", "helvet", "\\DeclareUnicodeCharacter", "\\setsansfont"} {
+			if strings.Contains(got, notwant) {
+				t.Errorf("%s: unexpected %q in output:\n%s", engine, notwant, got)
+			}
+		}
+	}
+}
+
+func TestUnicodeToLaTeX(t *testing.T) {
+	for _, test := range []struct {
+		r    rune
+		want string
+	}{
+		{'é', `\'{e}`},
+		{'í', `\'{\i{}}`},
+		{'ǘ', `\'{\"{u}}`},
+		{'Ç', `\c{C}`},
+		{'ß', `\ss{}`},
+		{'α', `\ensuremath{\alpha}`},
+		{'→', `\ensuremath{\rightarrow}`},
+		{'“', "``"},
+		{'€', `\texteuro{}`},
+		{'┼', "+"},
+	} {
+		got, ok := latex.UnicodeToLaTeX(test.r)
+		if !ok || got != test.want {
+			t.Errorf("%q: got %q, want %q", test.r, got, test.want)
+		}
+	}
+	if _, ok := latex.UnicodeToLaTeX('☃'); ok {
+		t.Error("snowman should not be mapped")
+	}
+	if got := latex.UnmappedRunes([]byte("naïve ☃ é ☃ 漢")); string(got) != "☃" {
+		t.Errorf("got unmapped runes %q", string(got))
+	}
+
+	// Combining sequences are normalized before declaring and substituting.
+	const md = "Café costs 5€ → “cheap”\n"
+	got := convert(t, latex.Config{DeclareUnicode: latex.UnicodeToLaTeX}, md)
+	for _, want := range []string{"\\DeclareUnicodeCharacter{00e9}{\\'{e}}", "\\DeclareUnicodeCharacter{20ac}{\\texteuro{}}", "Café costs 5€"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output:\n%s", want, got)
+		}
+	}
+	// Fragments have no preamble to declare characters in.
+	for _, cfg := range []latex.Config{{InlineUnicode: true}, {Fragment: true}} {
+		cfg.DeclareUnicode = latex.UnicodeToLaTeX
+		got = convert(t, cfg, md)
+		if !strings.Contains(got, "Caf\\'{e} costs 5\\texteuro{} \\ensuremath{\\rightarrow} ``cheap''") || strings.Contains(got, "DeclareUnicodeCharacter") {
+			t.Errorf("%+v: unexpected inline substitution:\n%s", cfg, got)
+		}
+	}
+	// \checkmark is defined by amssymb, which is otherwise only loaded for math.
+	got = convert(t, latex.Config{DeclareUnicode: latex.UnicodeToLaTeX}, "Done ✓\n")
+	if !strings.Contains(got, "\\usepackage{amssymb}") {
+		t.Errorf("amssymb not loaded for \\checkmark:\n%s", got)
+	}
+	if got = convert(t, latex.Config{}, "Done ✓\n"); strings.Contains(got, "amssymb") {
+		t.Errorf("amssymb loaded without DeclareUnicode:\n%s", got)
+	}
+}
+
+func TestScripts(t *testing.T) {
+	const cjk = "Release 1.2: 新しい機能\n"
+	got := convert(t, latex.Config{}, cjk)
+	for _, want := range []string{"\\usepackage{CJKutf8}", "\\begin{CJK}{UTF8}{min}\n", "\\end{CJK}\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("pdflatex: missing %q in output:\n%s", want, got)
+		}
+	}
+	got = convert(t, latex.Config{Engine: latex.XeLaTeX, Fonts: latex.Fonts{CJK: "Noto Serif CJK JP"}}, cjk)
+	if !strings.Contains(got, "\\usepackage{xeCJK}") || !strings.Contains(got, "\\setCJKmainfont{Noto Serif CJK JP}") || strings.Contains(got, "\\begin{CJK}") {
+		t.Errorf("xelatex: unexpected CJK setup:\n%s", got)
+	}
+
+	const rtl = "Hello שלום עולם, and مرحبا too.\n"
+	got = convert(t, latex.Config{Engine: latex.XeLaTeX}, rtl)
+	for _, want := range []string{
+		"\\usepackage{polyglossia}",
+		"\\setotherlanguage{hebrew}",
+		"\\newfontfamily\\arabicfont[Script=Arabic]{Noto Naskh Arabic}",
+		"Hello \\texthebrew{שלום עולם}, and \\textarabic{مرحبا} too.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("xelatex: missing %q in output:\n%s", want, got)
+		}
+	}
+	got = convert(t, latex.Config{Engine: latex.XeLaTeX}, "Only שלום.\n")
+	if !strings.Contains(got, "\\hebrewfont") || strings.Contains(got, "arabic") {
+		t.Errorf("xelatex: Arabic set up for Hebrew only text:\n%s", got)
+	}
+	got = convert(t, latex.Config{}, rtl)
+	if strings.Contains(got, "\\texthebrew") || !strings.Contains(got, "% goldmark-latex: Hebrew and Arabic text require") {
+		t.Errorf("pdflatex: unexpected RTL output:\n%s", got)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	got := convert(t, latex.Config{Language: "es"}, "Hola.\n")
+	if !strings.Contains(got, "\\usepackage[spanish]{babel}") {
+		t.Errorf("missing babel in output:\n%s", got)
+	}
+
+	const md = "# Résumé {lang=fr}\n\nDer Text.\n"
+	source := []byte(md)
+	doc := goldmark.New(goldmark.WithParserOptions(parser.WithAttribute())).Parser().Parse(text.NewReader(source))
+	doc.(*ast.Document).SetMeta(map[string]interface{}{"lang": "de"})
+	doc.LastChild().FirstChild().SetAttributeString("lang", []byte("en-GB"))
+	render := func(cfg latex.Config) string {
+		var output bytes.Buffer
+		r := renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(latex.NewRenderer(cfg), 1000)))
+		if err := r.Render(&output, source, doc); err != nil {
+			t.Fatal(err)
+		}
+		return output.String()
+	}
+	got = render(latex.Config{Language: "es"})
+	for _, want := range []string{
+		"\\usepackage[french,british,ngerman]{babel}",
+		"\\begin{otherlanguage}{french}\n\\section{Résumé}",
+		"\\foreignlanguage{british}{Der Text.}",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("pdflatex: missing %q in output:\n%s", want, got)
+		}
+	}
+	got = render(latex.Config{Engine: latex.LuaLaTeX})
+	for _, want := range []string{"\\usepackage{polyglossia}", "\\setdefaultlanguage{german}", "\\setotherlanguage{french}", "\\setotherlanguage{english}"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("lualatex: missing %q in output:\n%s", want, got)
+		}
+	}
+
+	// Tags that are not language names are not written to the output.
+	doc.(*ast.Document).SetMeta(map[string]interface{}{"lang": "english}\\input{/etc/passwd}%"})
+	doc.FirstChild().SetAttributeString("lang", []byte("fr}\\input{/etc/passwd}"))
+	var diags latex.DiagnosticCollector
+	for _, engine := range []latex.Engine{latex.PDFLaTeX, latex.XeLaTeX} {
+		diags.Reset()
+		got = render(latex.Config{Engine: engine, OnDiagnostic: diags.Report})
+		if strings.Contains(got, "passwd") || !strings.Contains(got, "\\section{Résumé}") {
+			t.Errorf("%s: invalid language written to output:\n%s", engine, got)
+		}
+		var codes []string
+		for _, d := range diags.Diagnostics() {
+			codes = append(codes, d.String()+" "+d.Code)
+		}
+		want := []string{
+			`0:0: warning: language "english}\\input{/etc/passwd}%" is not a language name, ignored invalid-language`,
+			`1:3: warning: lang attribute "fr}\\input{/etc/passwd}" is not a language name, ignored invalid-language`,
+		}
+		if strings.Join(codes, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s: got diagnostics\n%s\nwant\n%s", engine, strings.Join(codes, "\n"), strings.Join(want, "\n"))
+		}
+	}
+
+	for tag, want := range map[string]bool{"es": true, "pt_BR": true, "de-AT": true, "spanish": false, "es]{babel}": false, "": false} {
+		if got := latex.KnownLanguage(tag); got != want {
+			t.Errorf("KnownLanguage(%q) = %v, want %v", tag, got, want)
+		}
+	}
+}
+
+func TestMacros(t *testing.T) {
+	const md = "# Title\n\n> quoted **strong** *emph* `code` ***both***\n\n---\n\n- item\n\n```go\nx := 1\n```\n"
+	got := convert(t, latex.Config{Macros: latex.Macros{
+		Heading:       [6]latex.Macro{{Open: "\\chapter*{", Close: "}\\markboth{}{}"}},
+		Blockquote:    latex.Macro{Open: "\n\\begin{myquote}\n", Close: "\\end{myquote}\n"},
+		Emphasis:      [3]latex.Macro{1: {Open: "\\textbf{\\textit{", Close: "}}"}, 2: {Open: "\\alert{", Close: "}"}},
+		CodeSpan:      latex.Macro{Open: "\\lstinline|", Close: "|"},
+		ThematicBreak: latex.Macro{Open: "\n\\bigskip\\centerline{*}\n"},
+		ListItem:      latex.Macro{Open: "\\item ", Close: "\n"},
+		CodeBlock:     latex.Macro{Open: "\n\\begin{verbatim}", Close: "\\end{verbatim}\n"},
+	}}, md)
+	for _, want := range []string{
+		"\\chapter*{Title}\\markboth{}{}",
+		"\\begin{myquote}\nquoted \\textbf{\\textit{strong}} \\textit{emph} \\lstinline|code| \\alert{both}",
+		"\\end{myquote}\n",
+		"\\bigskip\\centerline{*}\n",
+		"\\item item\n",
+		"\\begin{verbatim}\nx := 1\n\\end{verbatim}\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output:\n%s", want, got)
+		}
+	}
+	for _, notwant := range []string{"{framed}", "{listings}", "\\hrulefill", "\\section"} {
+		if strings.Contains(got, notwant) {
+			t.Errorf("overridden construct left %q in output:\n%s", notwant, got)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	const md = "# One\n\nSee Goldmark.\n\n```go\nsecret\n```\n\n## Two\n"
+	got := convert(t, latex.Config{Hooks: map[ast.NodeKind]latex.Hook{
+		ast.KindHeading: func(w util.BufWriter, source []byte, n ast.Node, entering bool) (latex.HookResult, error) {
+			switch {
+			case n.(*ast.Heading).Level != 1:
+				return latex.HookContinue, nil
+			case entering:
+				_, _ = w.WriteString("\n\\clearpage")
+			default:
+				_, _ = w.WriteString("\\label{h1}")
+			}
+			return latex.HookContinue, nil
+		},
+		ast.KindText: func(w util.BufWriter, source []byte, n ast.Node, entering bool) (latex.HookResult, error) {
+			if !entering && bytes.Contains(n.Text(source), []byte("Goldmark")) {
+				_, _ = w.WriteString("\\index{Goldmark}")
+			}
+			return latex.HookContinue, nil
+		},
+		ast.KindFencedCodeBlock: func(w util.BufWriter, source []byte, n ast.Node, entering bool) (latex.HookResult, error) {
+			if entering {
+				_, _ = w.WriteString("\n% code omitted\n")
+				return latex.HookSkipChildren, nil
+			}
+			return latex.HookReplace, nil
+		},
+	}}, md)
+	for _, want := range []string{
+		"\n\\clearpage\n\\section{One}\\label{h1}",
+		"See Goldmark.\\index{Goldmark}",
+		"% code omitted\n",
+		"\n\\subsection{Two}",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "secret") || strings.Contains(got, "lstlisting") || strings.Count(got, "\\clearpage") != 1 {
+		t.Errorf("unexpected output:\n%s", got)
+	}
+}
+
+func TestExtension(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(latex.Extension(latex.ExtensionConfig{
+		Table: true, Strikethrough: true, TaskList: true, Linkify: true, Footnote: true, DefinitionList: true,
+		Typographer: true, Math: true, FrontMatter: true,
+	})))
+	const src = `---
+title: Release notes
+---
+Costs $5 and $6. Euler: $e^{i\pi}+1=0$.
+
+$$
+a^2 + b^2 = c^2
+$$
+
+| Name | Size |
+|:-----|-----:|
+| ~~old~~ | 1 |
+
+- [x] done
+- [ ] todo
+
+Note[^1], again[^1].
+
+Term
+: Definition.
+
+"Quoted" -- it's... at https://example.com
+
+[^1]: First.
+
+    Second.
+`
+	var output bytes.Buffer
+	err := md.Convert([]byte(src), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := output.String()
+	for _, want := range []string{
+		"\\title{Release notes}",
+		"\\usepackage{amsmath}",
+		"\\usepackage[normalem]{ulem}",
+		"Costs \\$5 and \\$6. Euler: $e^{i\\pi}+1=0$.",
+		"\\[\na^2 + b^2 = c^2\n\\]\n",
+		"\\begin{tabular}{lr}\n\\hline\nName & Size \\\\\n\\hline\n\\sout{old} & 1 \\\\\n\\hline\n\\end{tabular}",
+		"\\item~ $\\boxtimes$ done",
+		"\\item~ $\\square$ todo",
+		"Note\\footnote{First.\\par Second.}, again\\footnotemark[1].",
+		"\\begin{description}\n\\item[{Term}] Definition.\n",
+		"``Quoted'' -- it's\\ldots{} at \\href{https://example.com}{https://example.com}",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "<") || strings.Contains(got, "[^1]") {
+		t.Errorf("unrendered markdown or HTML in output:\n%s", got)
+	}
+
+	// Math is written as is unless it uses commands other than known math commands.
+	md = goldmark.New(goldmark.WithExtensions(latex.Extension(latex.ExtensionConfig{Math: true, Config: latex.Config{Fragment: true}})))
+	for _, test := range []struct {
+		math string
+		safe bool
+	}{
+		{math: `$\alpha \\ \begin{pmatrix} a \\ \sqrt{b} \end{pmatrix}\,\text{ok}$`, safe: true},
+		{math: `$\input{/etc/passwd}$`},
+		{math: `$\^^69nput{/etc/passwd}$`},
+		{math: `$\lstinputlisting{/etc/passwd}$`},
+		{math: `$$\verbatiminput{/etc/passwd}$$`},
+		{math: `$\text{\input{/etc/passwd}}$`},
+		{math: `$x \end{document}$`},
+		{math: `$a \\input b$`, safe: true}, // A line break followed by text.
+	} {
+		output.Reset()
+		err = md.Convert([]byte(test.math+"\n"), &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rendered := !strings.Contains(output.String(), "Skipped math"); rendered != test.safe {
+			t.Errorf("%s: got rendered %v, want %v:\n%s", test.math, rendered, test.safe, output.String())
+		}
+	}
+}
+
+var (
+	kindAdmonition = ast.NewNodeKind("Admonition")
+	kindBadge      = ast.NewNodeKind("Badge")
+)
+
+type admonition struct{ ast.BaseBlock }
+
+func (n *admonition) Kind() ast.NodeKind            { return kindAdmonition }
+func (n *admonition) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+type badge struct{ ast.BaseInline }
+
+func (n *badge) Kind() ast.NodeKind            { return kindBadge }
+func (n *badge) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+func TestFallback(t *testing.T) {
+	const md = "Before *new*.\n\nInside text.\n"
+	source := []byte(md)
+	doc := goldmark.New().Parser().Parse(text.NewReader(source))
+	// Wrap the emphasis in a badge and replace the second paragraph with an admonition.
+	first := doc.FirstChild()
+	emph := first.FirstChild().NextSibling()
+	b := &badge{}
+	first.ReplaceChild(first, emph, b)
+	b.AppendChild(b, emph)
+	second := first.NextSibling()
+	adm := &admonition{}
+	for c := second.FirstChild(); c != nil; c = second.FirstChild() {
+		adm.AppendChild(adm, c)
+	}
+	doc.ReplaceChild(doc, second, adm)
+
+	var output bytes.Buffer
+	r := renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(latex.NewRenderer(latex.Config{}), 1000)))
+	err := r.Render(&output, source, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := output.String()
+	for _, want := range []string{
+		"Before % goldmark-latex: unsupported inline Badge rendered as text\n\\textit{new}.",
+		"\n% goldmark-latex: unsupported block Admonition rendered as paragraph\nInside text.\\\\\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output:\n%s", want, got)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	const md = "# Title\n\nSome <b>bold</b> text.\n\n![logo](logo.png)\n\n<div>\nblock\n</div>\n\n```brainfuck\n\\end{lstlisting}\n```\n"
+	var diags latex.DiagnosticCollector
+	convert(t, latex.Config{OnDiagnostic: diags.Report, Preamble: []byte("\\documentclass{article}\n")}, md)
+	var got []string
+	for _, d := range diags.Diagnostics() {
+		got = append(got, d.Code+" "+d.Kind.String()+" "+d.String())
+	}
+	want := []string{
+		"missing-package  0:0: info: package listings is used by the document but missing from the preamble, adding it",
+		"missing-package  0:0: info: package textcomp is used by the document but missing from the preamble, adding it",
+		"raw-html RawHTML 3:6: warning: inline HTML skipped",
+		"raw-html RawHTML 3:13: warning: inline HTML skipped",
+		"image Image 5:3: warning: image \"logo.png\" skipped",
+		"html-block HTMLBlock 7:1: warning: HTML block skipped",
+		"unknown-language FencedCodeBlock 11:4: warning: code block language \"brainfuck\" not supported by listings, rendered without highlighting",
+		"unsafe-line FencedCodeBlock 12:1: warning: possibly unsafe code line commented out",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	const md = "# Title\n\nPara one\nline two.\n\n- item\n- two\n\n> quote\n\n```go\nx\n```\n"
+	plain := convert(t, latex.Config{}, md)
+	marked := convert(t, latex.Config{LineMarkers: true}, md)
+	if !strings.Contains(marked, "%!md:6:3\n") || !strings.Contains(marked, "%!md:11:4\n") {
+		t.Errorf("missing line markers:\n%s", marked)
+	}
+	stripped, sm := latex.ExtractSourceMap([]byte(marked), false)
+	if string(stripped) != plain {
+		t.Errorf("stripping markers changed output:\n%s\nwant:\n%s", stripped, plain)
+	}
+	texLines := strings.Split(plain, "\n")
+	for _, test := range []struct {
+		tex    string
+		mdLine int
+	}{
+		{tex: "\\section{Title}Para one", mdLine: 1},
+		{tex: "line two.\\\\", mdLine: 3},
+		{tex: "\\item~ two", mdLine: 6},
+		{tex: "quote\\\\", mdLine: 9},
+		{tex: "x", mdLine: 11},
+	} {
+		texLine := 0
+		for i, line := range texLines {
+			if line == test.tex {
+				texLine = i + 1
+			}
+		}
+		line, _, ok := sm.Lookup(texLine)
+		if !ok || line != test.mdLine {
+			t.Errorf("line %d %q: got markdown line %d, want %d", texLine, test.tex, line, test.mdLine)
+		}
+	}
+	_, kept := latex.ExtractSourceMap([]byte(marked), true)
+	if len(kept.Mappings) != len(sm.Mappings) {
+		t.Errorf("got %d mappings keeping markers, want %d", len(kept.Mappings), len(sm.Mappings))
+	}
+}
+
+func TestRendererConcurrent(t *testing.T) {
+	// Run with -race to check the Renderer is safe to share between goroutines.
+	var diags latex.DiagnosticCollector
+	cfg := latex.Config{OnDiagnostic: diags.Report, LineMarkers: true}
+	r := renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(latex.NewRenderer(cfg), 1000)))
+	md := goldmark.New(goldmark.WithRenderer(r))
+	inputs := []string{string(data), "# Title\n\nSome <b>html</b> and `code`.\n", "- a\n- b\n\n> quote\n"}
+	want := make([]string, len(inputs))
+	for i, input := range inputs {
+		want[i] = convert(t, cfg, input)
+	}
+	diags.Reset()
+	const goroutines = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*len(inputs))
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, input := range inputs {
+				var output bytes.Buffer
+				err := md.Convert([]byte(input), &output)
+				if err == nil && output.String() != want[i] {
+					err = fmt.Errorf("input %d: output differs from sequential render", i)
+				}
+				if err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(diags.Diagnostics()) == 0 {
+		t.Error("no diagnostics reported")
+	}
+}
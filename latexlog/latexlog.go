@@ -0,0 +1,233 @@
+// Package latexlog parses the .log files written by pdflatex, xelatex and
+// lualatex and maps the problems found in them back to markdown lines using
+// the source map of the LaTeX output. See latex.ExtractSourceMap.
+package latexlog
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	latex "github.com/soypat/goldmark-latex"
+)
+
+// Kind of a log Entry.
+type Kind uint8
+
+const (
+	// Error is a TeX or LaTeX error, lines starting with "!".
+	Error Kind = iota
+	// Warning is a LaTeX, class or package warning.
+	Warning
+	// BadBox is an overfull or underfull box warning.
+	BadBox
+	// UndefinedReference is an undefined \ref or \cite warning.
+	UndefinedReference
+	// MissingCharacter is a character missing from the font in use.
+	MissingCharacter
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case BadBox:
+		return "bad box"
+	case UndefinedReference:
+		return "undefined reference"
+	case MissingCharacter:
+		return "missing character"
+	}
+	return "unknown"
+}
+
+// Entry is a problem reported in a log file.
+type Entry struct {
+	Kind Kind
+	// File being read by TeX when the problem was found, as written in the log. Empty if unknown.
+	File string
+	// Line in File, starting at 1. Zero if unknown.
+	Line    int
+	Message string
+}
+
+// maxPrintLine is the length at which TeX wraps log lines.
+const maxPrintLine = 79
+
+var (
+	fileLineError = regexp.MustCompile(`^(.*\.tex):(\d+): (.*)$`)
+	errorLine     = regexp.MustCompile(`^l\.(\d+)`)
+	warning       = regexp.MustCompile(`^(?:LaTeX|Package \S+|Class \S+)(?: \S+)? Warning: (.*)$`)
+	inputLine     = regexp.MustCompile(`on input line (\d+)\.`)
+	undefined     = regexp.MustCompile("(?:Reference|Citation) .* undefined|There were undefined references")
+	badBox        = regexp.MustCompile(`^(?:Over|Under)full \\[hv]box .*?(?:at lines? (\d+)(?:--\d+)?)?$`)
+	missingChar   = regexp.MustCompile(`^Missing character: There is no (.+) in font (.+)!$`)
+)
+
+// Parse returns the problems reported in a TeX log.
+func Parse(r io.Reader) ([]Entry, error) {
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	var files []string // Stack of files being read.
+	file := func() string {
+		for i := len(files) - 1; i >= 0; i-- {
+			if files[i] != "" {
+				return files[i]
+			}
+		}
+		return ""
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "! "):
+			e := Entry{Kind: Error, File: file(), Message: strings.TrimPrefix(line, "! ")}
+			// The line number follows the error context, which may contain anything.
+			for j := i + 1; j < len(lines) && j <= i+10; j++ {
+				if m := errorLine.FindStringSubmatch(lines[j]); m != nil {
+					e.Line, _ = strconv.Atoi(m[1])
+					i = j
+					break
+				}
+			}
+			entries = append(entries, e)
+			continue
+		case fileLineError.MatchString(line):
+			m := fileLineError.FindStringSubmatch(line)
+			n, _ := strconv.Atoi(m[2])
+			entries = append(entries, Entry{Kind: Error, File: m[1], Line: n, Message: m[3]})
+			for j := i + 1; j < len(lines) && j <= i+10; j++ {
+				if errorLine.MatchString(lines[j]) {
+					i = j
+					break
+				}
+			}
+			continue
+		case warning.MatchString(line):
+			msg := warning.FindStringSubmatch(line)[1]
+			// Messages continue on indented lines until a blank line.
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" && strings.HasPrefix(lines[i+1], "(") && strings.Contains(lines[i+1], "  ") {
+				i++
+				_, rest, _ := strings.Cut(lines[i], ")")
+				msg += " " + strings.TrimSpace(rest)
+			}
+			e := Entry{Kind: Warning, File: file(), Message: msg}
+			if undefined.MatchString(msg) {
+				e.Kind = UndefinedReference
+			}
+			if m := inputLine.FindStringSubmatch(msg); m != nil {
+				e.Line, _ = strconv.Atoi(m[1])
+			}
+			entries = append(entries, e)
+			continue
+		case badBox.MatchString(line):
+			m := badBox.FindStringSubmatch(line)
+			e := Entry{Kind: BadBox, File: file(), Message: line}
+			e.Line, _ = strconv.Atoi(m[1])
+			entries = append(entries, e)
+			continue
+		case missingChar.MatchString(line):
+			entries = append(entries, Entry{Kind: MissingCharacter, File: file(), Message: line})
+			continue
+		}
+		files = scanFiles(files, line)
+	}
+	return entries, nil
+}
+
+// readLines reads the lines of a log, joining lines wrapped by TeX.
+func readLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	wrapped := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if wrapped {
+			lines[len(lines)-1] += line
+		} else {
+			lines = append(lines, line)
+		}
+		wrapped = len(line) == maxPrintLine
+	}
+	return lines, scanner.Err()
+}
+
+// scanFiles updates the stack of open files with the parentheses of a log line.
+// TeX writes "(" followed by the file name when it starts reading a file and ")" when done.
+// Parentheses not followed by a file name are kept with an empty name to keep the stack balanced.
+func scanFiles(files []string, line string) []string {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '(':
+			end := strings.IndexAny(line[i+1:], " ()")
+			if end < 0 {
+				end = len(line) - i - 1
+			}
+			name := line[i+1 : i+1+end]
+			if !strings.Contains(name, ".") || strings.HasPrefix(name, ".") && !strings.HasPrefix(name, "./") && !strings.HasPrefix(name, "../") {
+				name = ""
+			}
+			files = append(files, name)
+		case ')':
+			if len(files) > 0 {
+				files = files[:len(files)-1]
+			}
+		}
+	}
+	return files
+}
+
+// Diagnostic is a log Entry mapped to the markdown source of a LaTeX file.
+type Diagnostic struct {
+	Entry
+	// Markdown source file of the source map. Empty if the entry could not be mapped.
+	Source string
+	// Position in the markdown source, starting at 1. Zero if unknown.
+	Line, Column int
+}
+
+// String returns the diagnostic formatted as "file.md:LINE:COL: kind: message", falling back
+// to the position in the LaTeX file for entries that could not be mapped.
+func (d Diagnostic) String() string {
+	var pos string
+	switch {
+	case d.Source != "" && d.Line > 0:
+		pos = d.Source + ":" + strconv.Itoa(d.Line) + ":" + strconv.Itoa(d.Column)
+	case d.Source != "":
+		pos = d.Source
+	case d.Entry.File != "" && d.Entry.Line > 0:
+		pos = d.Entry.File + ":" + strconv.Itoa(d.Entry.Line)
+	case d.Entry.File != "":
+		pos = d.Entry.File
+	default:
+		pos = "<log>"
+	}
+	return pos + ": " + d.Kind.String() + ": " + d.Message
+}
+
+// Map maps entries found in texFile to markdown positions with the source map
+// of texFile. Entries of files other than texFile, such as packages, are not mapped.
+// Entries with an unknown file are assumed to come from texFile.
+func Map(entries []Entry, texFile string, sm latex.SourceMap) []Diagnostic {
+	diags := make([]Diagnostic, len(entries))
+	for i, e := range entries {
+		diags[i].Entry = e
+		if e.File != "" && filepath.Base(e.File) != filepath.Base(texFile) {
+			continue
+		}
+		diags[i].Source = sm.Source
+		if e.Line > 0 {
+			diags[i].Line, diags[i].Column, _ = sm.Lookup(e.Line)
+		}
+	}
+	return diags
+}
@@ -0,0 +1,72 @@
+package latexlog_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	latex "github.com/soypat/goldmark-latex"
+	"github.com/soypat/goldmark-latex/latexlog"
+)
+
+func parseFile(t *testing.T, name string) []latexlog.Entry {
+	t.Helper()
+	fp, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	entries, err := latexlog.Parse(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		log  string
+		want []latexlog.Entry
+	}{
+		{"testdata/pdflatex.log", []latexlog.Entry{
+			{latexlog.Warning, "/usr/share/texlive/texmf-dist/tex/latex/hyperref/hyperref.sty", 4, "Option `pdfauthor' has already been used, setting the option has no effect on input line 4."},
+			{latexlog.Error, "./notes.tex", 12, "Undefined control sequence."},
+			{latexlog.BadBox, "./notes.tex", 20, `Overfull \hbox (15.0pt too wide) in paragraph at lines 20--22`},
+			{latexlog.UndefinedReference, "./notes.tex", 25, "Reference `sec:missing' on page 1 undefined on input line 25."},
+			{latexlog.UndefinedReference, "./notes.tex", 0, "There were undefined references."},
+		}},
+		{"testdata/xelatex.log", []latexlog.Entry{
+			{latexlog.MissingCharacter, "./slides.tex", 0, "Missing character: There is no 字 in font [lmroman10-regular]:mapping=tex-text;!"},
+			{latexlog.Error, "./slides.tex", 9, "Missing $ inserted."},
+			{latexlog.BadBox, "./slides.tex", 14, `Underfull \hbox (badness 10000) in paragraph at lines 14--15`},
+		}},
+	} {
+		got := parseFile(t, test.log)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got entries\n%q\nwant\n%q", test.log, got, test.want)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	entries := parseFile(t, "testdata/pdflatex.log")
+	sm := latex.SourceMap{Source: "notes.md", Mappings: []latex.LineMapping{
+		{TeXLine: 10, Line: 3, Column: 1},
+		{TeXLine: 18, Line: 7, Column: 1},
+		{TeXLine: 24, Line: 11, Column: 3},
+	}}
+	var got []string
+	for _, d := range latexlog.Map(entries, "build/notes.tex", sm) {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"/usr/share/texlive/texmf-dist/tex/latex/hyperref/hyperref.sty:4: warning: Option `pdfauthor' has already been used, setting the option has no effect on input line 4.",
+		"notes.md:3:1: error: Undefined control sequence.",
+		`notes.md:7:1: bad box: Overfull \hbox (15.0pt too wide) in paragraph at lines 20--22`,
+		"notes.md:11:3: undefined reference: Reference `sec:missing' on page 1 undefined on input line 25.",
+		"notes.md: undefined reference: There were undefined references.",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got diagnostics\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,55 @@
+package latex
+
+import "github.com/yuin/goldmark/util"
+
+// Macro is the LaTeX written when entering (Open) and exiting (Close) a node.
+// A zero Macro keeps the renderer's default.
+type Macro struct {
+	Open  string
+	Close string
+}
+
+// IsZero reports whether m is the zero Macro.
+func (m Macro) IsZero() bool { return m == Macro{} }
+
+// Macros overrides the LaTeX written for markdown constructs. The
+// default is kept for every zero valued field. For example:
+//
+//	latex.Macros{
+//		Blockquote:    latex.Macro{Open: "\n\\begin{myquote}\n", Close: "\\end{myquote}\n"},
+//		Emphasis:      [3]latex.Macro{2: {Open: "\\textbf{\\textit{", Close: "}}"}},
+//		ThematicBreak: latex.Macro{Open: "\n\\bigskip\\centerline{*}\n"},
+//	}
+//
+// Packages needed by a Macro must be loaded by the preamble.
+// Overridden blockquotes and code blocks do not load framed nor listings.
+type Macros struct {
+	// Headings by markdown level, H1 first. Heading offset and document class
+	// are ignored for overridden levels. Document mode only.
+	Heading [6]Macro
+	// Emphasis by level: *a*, **a** and ***a***. The level 3 macro replaces
+	// the nested level 1 and 2 emphasis goldmark parses ***a*** into.
+	Emphasis   [3]Macro
+	CodeSpan   Macro
+	Blockquote Macro
+	// Code blocks, fenced or indented. Contents are written raw between Open and Close.
+	CodeBlock     Macro
+	List          Macro
+	OrderedList   Macro
+	ListItem      Macro
+	ThematicBreak Macro
+}
+
+// writeMacro writes the Open or Close string of m depending on entering.
+// It reports whether m is set; the caller writes its default LaTeX otherwise.
+func writeMacro(w util.BufWriter, m Macro, entering bool) bool {
+	if m.IsZero() {
+		return false
+	}
+	if entering {
+		_, _ = w.WriteString(m.Open)
+	} else {
+		_, _ = w.WriteString(m.Close)
+	}
+	return true
+}
@@ -0,0 +1,307 @@
+package latex
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindMath is the NodeKind of Math nodes.
+var KindMath = ast.NewNodeKind("Math")
+
+// Math is an inline node of TeX math between $ delimiters, or $$ delimiters
+// for display math. Its children are Text nodes with the math as is.
+type Math struct {
+	ast.BaseInline
+	// Display is true for math between $$ delimiters.
+	Display bool
+}
+
+// Kind implements ast.Node.
+func (n *Math) Kind() ast.NodeKind { return KindMath }
+
+// Dump implements ast.Node.
+func (n *Math) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Display": boolString(n.Display)}, nil)
+}
+
+// KindMathBlock is the NodeKind of MathBlock nodes.
+var KindMathBlock = ast.NewNodeKind("MathBlock")
+
+// MathBlock is a block of display math between lines starting and ending with $$.
+// Its lines hold the math as is.
+type MathBlock struct {
+	ast.BaseBlock
+	closed bool // Closing $$ found on the opening line.
+}
+
+// Kind implements ast.Node.
+func (n *MathBlock) Kind() ast.NodeKind { return KindMathBlock }
+
+// IsRaw implements ast.Node.
+func (n *MathBlock) IsRaw() bool { return true }
+
+// Dump implements ast.Node.
+func (n *MathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+var mathDelim = []byte("$$")
+
+// mathParser parses inline Math. Following pandoc, the opening $ must not be
+// followed by a space and the closing $ must not be preceded by a space nor
+// followed by a digit, so that "$5 and $6" is not math.
+type mathParser struct{}
+
+func (mathParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	delim := 1
+	if len(line) > 1 && line[1] == '$' {
+		delim = 2
+	}
+	content := line[delim:]
+	end := -1
+loop:
+	for i := 0; i < len(content); i++ {
+		switch {
+		case content[i] == '\\':
+			i++ // Escaped character, i.e: \$.
+		case content[i] != '$':
+		case delim == 1 || i+1 < len(content) && content[i+1] == '$':
+			end = i
+			break loop
+		}
+	}
+	if end <= 0 {
+		return nil
+	}
+	if delim == 1 && (util.IsSpace(content[0]) || util.IsSpace(content[end-1]) ||
+		end+1 < len(content) && content[end+1] >= '0' && content[end+1] <= '9') {
+		return nil
+	}
+	n := &Math{Display: delim == 2}
+	start := segment.Start + delim
+	n.AppendChild(n, ast.NewTextSegment(text.NewSegment(start, start+end)))
+	block.Advance(end + 2*delim)
+	return n
+}
+
+// mathBlockParser parses a MathBlock.
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], mathDelim) {
+		return nil, parser.NoChildren
+	}
+	node := &MathBlock{}
+	rest := line[pos+2:]
+	start := segment.Start + pos + 2
+	if end := bytes.Index(rest, mathDelim); end >= 0 {
+		// Single line block such as $$x^2$$.
+		if !util.IsBlank(rest[end+2:]) {
+			return nil, parser.NoChildren
+		}
+		node.Lines().Append(text.NewSegment(start, start+end))
+		node.closed = true
+		reader.Advance(segment.Len() - 1)
+		return node, parser.NoChildren
+	}
+	if !util.IsBlank(rest) {
+		node.Lines().Append(text.NewSegment(start, segment.Stop))
+	}
+	reader.Advance(segment.Len() - 1)
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	if node.(*MathBlock).closed {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	trimmed := util.TrimRightSpace(line)
+	if bytes.HasSuffix(trimmed, mathDelim) {
+		if content := trimmed[:len(trimmed)-2]; !util.IsBlank(content) {
+			node.Lines().Append(text.NewSegment(segment.Start, segment.Start+len(content)))
+		}
+		reader.Advance(segment.Len() - 1)
+		return parser.Close
+	}
+	node.Lines().Append(segment)
+	reader.Advance(segment.Len() - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (mathBlockParser) CanInterruptParagraph() bool { return true }
+
+func (mathBlockParser) CanAcceptIndentedLine() bool { return false }
+
+func (r *Renderer) renderMath(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*Math)
+	var math []byte
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if t, ok := c.(*ast.Text); ok {
+			math = append(math, t.Segment.Value(source)...)
+		}
+	}
+	switch {
+	case !r.Config.Unsafe && !safeMath(math):
+		_, _ = w.WriteString("\n% goldmark-latex: Skipped math due to possibly unsafe content\n")
+		r.diagnose(source, n, SeverityWarning, CodeUnsafeMath, "possibly unsafe math skipped")
+	case n.Display:
+		_, _ = w.WriteString("\\[")
+		_, _ = w.Write(math)
+		_, _ = w.WriteString("\\]")
+	default:
+		_ = w.WriteByte('$')
+		_, _ = w.Write(math)
+		_ = w.WriteByte('$')
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *Renderer) renderMathBlock(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	var math []byte
+	for i := 0; i < n.Lines().Len(); i++ {
+		line := n.Lines().At(i)
+		math = append(math, line.Value(source)...)
+	}
+	if !r.Config.Unsafe && !safeMath(math) {
+		_, _ = w.WriteString("\n% goldmark-latex: Skipped math block due to possibly unsafe content\n")
+		r.diagnose(source, n, SeverityWarning, CodeUnsafeMath, "possibly unsafe math block skipped")
+		return ast.WalkContinue, nil
+	}
+	_, _ = w.WriteString("\n\\[\n")
+	_, _ = w.Write(math)
+	if len(math) > 0 && math[len(math)-1] != '\n' {
+		_ = w.WriteByte('\n')
+	}
+	_, _ = w.WriteString("\\]\n")
+	return ast.WalkContinue, nil
+}
+
+// safeMath reports whether math only uses the commands in mathCommands
+// and the environments in mathEnvironments. TeX's ^^ notation, which can
+// write any character and so any command, is not allowed.
+func safeMath(math []byte) bool {
+	if bytes.Contains(math, []byte("^^")) {
+		return false
+	}
+	for i := 0; i < len(math); i++ {
+		if math[i] != '\\' {
+			continue
+		}
+		start := i + 1
+		end := start
+		for end < len(math) && (math[end] >= 'a' && math[end] <= 'z' || math[end] >= 'A' && math[end] <= 'Z') {
+			end++
+		}
+		if end == start {
+			// Control symbols such as \, and \{ only typeset or space.
+			i++
+			continue
+		}
+		name := string(math[start:end])
+		if !mathCommands[name] {
+			return false
+		}
+		if name == "begin" || name == "end" {
+			env, ok := mathArgument(math[end:])
+			if !ok || !mathEnvironments[env] {
+				return false
+			}
+		}
+		i = end - 1
+	}
+	return true
+}
+
+// mathArgument returns the braced argument at the start of b, skipping spaces.
+func mathArgument(b []byte) (string, bool) {
+	b = bytes.TrimLeft(b, " \t\n")
+	if len(b) == 0 || b[0] != '{' {
+		return "", false
+	}
+	end := bytes.IndexByte(b, '}')
+	if end < 0 {
+		return "", false
+	}
+	return string(b[1:end]), true
+}
+
+// mathCommands are the commands allowed in math when Config.Unsafe is not set:
+// the math commands of LaTeX, amsmath and amssymb which neither access files
+// nor define commands.
+var mathCommands = wordSet(`
+	alpha beta gamma delta epsilon varepsilon zeta eta theta vartheta iota kappa varkappa
+	lambda mu nu xi omicron pi varpi rho varrho sigma varsigma tau upsilon phi varphi chi psi omega
+	Gamma Delta Theta Lambda Xi Pi Sigma Upsilon Phi Psi Omega digamma aleph beth gimel daleth
+	pm mp times div cdot ast star circ bullet oplus ominus otimes oslash odot cap cup sqcap sqcup
+	vee wedge setminus smallsetminus wr diamond bigtriangleup bigtriangledown triangleleft triangleright
+	lhd rhd unlhd unrhd uplus amalg dagger ddagger land lor neg lnot
+	leq le geq ge neq ne equiv approx cong sim simeq propto prec succ preceq succeq ll gg
+	subset supset subseteq supseteq subsetneq supsetneq sqsubset sqsupset sqsubseteq sqsupseteq
+	in ni notin vdash dashv models perp parallel mid nmid asymp bowtie smile frown doteq
+	leqslant geqslant lesssim gtrsim lll ggg triangleq nleq ngeq
+	leftarrow rightarrow to gets uparrow downarrow updownarrow leftrightarrow
+	Leftarrow Rightarrow Uparrow Downarrow Updownarrow Leftrightarrow
+	longleftarrow longrightarrow longleftrightarrow Longleftarrow Longrightarrow Longleftrightarrow
+	mapsto longmapsto hookleftarrow hookrightarrow nearrow searrow swarrow nwarrow
+	iff implies impliedby rightleftharpoons leftharpoonup rightharpoonup xrightarrow xleftarrow
+	infty nabla partial forall exists nexists emptyset varnothing ell hbar imath jmath Re Im wp
+	prime angle triangle square blacksquare top bot surd flat natural sharp
+	clubsuit diamondsuit heartsuit spadesuit ldots cdots vdots ddots dots dotsc dotsb
+	therefore because complement checkmark
+	sum prod coprod int iint iiint oint bigcap bigcup bigsqcup bigvee bigwedge
+	bigodot bigotimes bigoplus biguplus
+	lim limsup liminf sup inf max min arg det dim exp gcd hom ker lg ln log Pr
+	sin cos tan cot sec csc arcsin arccos arctan sinh cosh tanh coth deg
+	left right middle big Big bigg Bigg bigl bigr Bigl Bigr biggl biggr Biggl Biggr
+	langle rangle lfloor rfloor lceil rceil lvert rvert lVert rVert vert Vert backslash
+	hat widehat tilde widetilde bar overline underline vec dot ddot acute grave breve check
+	overbrace underbrace overrightarrow overleftarrow
+	frac dfrac tfrac cfrac binom dbinom tbinom sqrt stackrel overset underset substack
+	not pmod bmod mod pod boxed tag notag nonumber
+	mathrm mathbf mathit mathsf mathtt mathcal mathbb mathfrak boldsymbol operatorname
+	text textrm textbf textit textsf texttt emph
+	displaystyle textstyle scriptstyle scriptscriptstyle limits nolimits
+	quad qquad enspace thinspace negthinspace phantom hphantom vphantom
+	begin end
+`)
+
+// mathEnvironments are the environments allowed in math when Config.Unsafe is not set.
+var mathEnvironments = wordSet(`
+	matrix pmatrix bmatrix Bmatrix vmatrix Vmatrix smallmatrix cases aligned alignedat gathered split array subarray
+`)
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
@@ -0,0 +1,188 @@
+package latex
+
+import (
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+)
+
+// Package is a LaTeX package loaded in the preamble with \usepackage.
+type Package struct {
+	Name    string
+	Options []string
+}
+
+// String returns the \usepackage command that loads the package.
+func (p Package) String() string {
+	var b strings.Builder
+	b.WriteString("\\usepackage")
+	if len(p.Options) > 0 {
+		b.WriteByte('[')
+		b.WriteString(strings.Join(p.Options, ","))
+		b.WriteByte(']')
+	}
+	b.WriteByte('{')
+	b.WriteString(p.Name)
+	b.WriteByte('}')
+	return b.String()
+}
+
+// defaultPackages are the packages loaded by the default preamble
+// and the features that require them. A package is loaded when all of its
+// features are present, packages with no feature are always loaded.
+var defaultPackages = []struct {
+	pkg  Package
+	feat feature
+}{
+	{Package{Name: "graphicx"}, featFull},
+	{Package{Name: "xcolor", Options: []string{"dvipsnames"}}, featListing | featPreamble}, // Colors used by \lstset.
+	{Package{Name: "listings"}, featListing},
+	{Package{Name: "geometry", Options: []string{"margin=1in"}}, 0},
+	{Package{Name: "inputenc", Options: []string{"utf8"}}, featPDFEngine},
+	{Package{Name: "fontenc", Options: []string{"T1"}}, featPDFEngine},
+	{Package{Name: "amsmath"}, featMath},
+	{Package{Name: "amssymb"}, featMath | featPDFEngine}, // Symbols are provided by unicode-math with Unicode engines.
+	{Package{Name: "fontspec"}, featUnicodeEngine},
+	{Package{Name: "unicode-math"}, featUnicodeEngine},
+	{Package{Name: "verbatim"}, featFull},
+	{Package{Name: "ulem", Options: []string{"normalem"}}, featStrike},
+	{Package{Name: "hyperref"}, featLink},
+	{Package{Name: "textcomp"}, featListing}, // Required for lstlisting to render `'` as is using upquote=true.
+	{Package{Name: "framed"}, featFramed},    // For block quotes.
+	{Package{Name: "CJKutf8"}, featCJK | featPDFEngine},
+	{Package{Name: "xeCJK"}, featCJK | featXeTeX},
+	{Package{Name: "luatexja-fontspec"}, featCJK | featLuaTeX},
+}
+
+// feature is a set of LaTeX constructs emitted by the renderer
+// that depend on a package being loaded.
+type feature uint32
+
+const (
+	featLink feature = 1 << iota
+	featListing
+	featFramed
+	featStrike
+	featMath
+	// featFull marks packages only loaded by the full default preamble.
+	featFull
+	// featPreamble marks packages used by the settings of the default preamble
+	// rather than by the rendered document.
+	featPreamble
+	// Set depending on the engine, see Engine.Unicode.
+	featPDFEngine
+	featUnicodeEngine
+	featXeTeX
+	featLuaTeX
+	// Set when the document contains text in the corresponding scripts.
+	featCJK
+	featRTL
+
+	featEngines = featPDFEngine | featUnicodeEngine | featXeTeX | featLuaTeX
+	featScripts = featCJK | featRTL
+)
+
+// RequiredPackages returns the packages needed to typeset the LaTeX rendered
+// for doc. It is meant to be used with Config.Fragment so that the host document
+// can load the packages the fragment uses. doc is usually the result of parsing
+// source with goldmark's parser.
+func (r *Renderer) RequiredPackages(doc ast.Node, source []byte) []Package {
+	pkgs := packagesFor(r.features(doc, source))
+	if lang := r.languages(doc, source).Package(r.Config.Engine); lang.Name != "" {
+		pkgs = append(pkgs, lang)
+	}
+	return pkgs
+}
+
+// packagesFor returns the packages of the default preamble required by the
+// constructs in feats. Packages only needed by the full preamble or by the
+// engine alone, such as inputenc, are left to the preamble.
+func packagesFor(feats feature) (pkgs []Package) {
+	for _, dp := range defaultPackages {
+		if dp.feat&(featFull|featPreamble) != 0 || dp.feat&^featEngines == 0 {
+			continue
+		}
+		if feats&dp.feat == dp.feat {
+			pkgs = append(pkgs, dp.pkg)
+		}
+	}
+	return pkgs
+}
+
+// preamblePackages returns the packages of the default preamble needed for feats.
+// The language package, if any, is loaded before hyperref.
+func preamblePackages(feats feature, lang Package) (pkgs []Package) {
+	feats |= featPreamble
+	for _, dp := range defaultPackages {
+		if lang.Name != "" && dp.pkg.Name == "hyperref" {
+			pkgs = append(pkgs, lang)
+			lang.Name = ""
+		}
+		if feats&dp.feat == dp.feat {
+			pkgs = append(pkgs, dp.pkg)
+		}
+	}
+	if lang.Name != "" {
+		pkgs = append(pkgs, lang)
+	}
+	return pkgs
+}
+
+// missingPackages returns the packages in pkgs not loaded by preamble.
+// If the preamble can not be parsed no packages are returned.
+func missingPackages(preamble []byte, pkgs []Package) (missing []Package) {
+	pb, err := ParsePreamble(preamble)
+	if err != nil {
+		return nil
+	}
+	for _, pkg := range pkgs {
+		if pb.packageIndex(pkg.Name) < 0 {
+			missing = append(missing, pkg)
+		}
+	}
+	return missing
+}
+
+// features walks the tree rooted at doc and returns the features
+// the renderer emits when rendering it.
+func (r *Renderer) features(doc ast.Node, source []byte) (feats feature) {
+	switch r.Config.Engine {
+	case XeLaTeX:
+		feats = featUnicodeEngine | featXeTeX
+	case LuaLaTeX:
+		feats = featUnicodeEngine | featLuaTeX
+	default:
+		feats = featPDFEngine
+	}
+	feats |= scriptFeatures(source)
+	if feats&featPDFEngine != 0 && r.Config.DeclareUnicode != nil && r.declaresAMSSymbols(source) {
+		feats |= featMath // Loads amssymb.
+	}
+	if doc == nil {
+		return feats
+	}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n.Kind() {
+		case ast.KindLink, ast.KindAutoLink:
+			feats |= featLink
+		case east.KindStrikethrough:
+			feats |= featStrike
+		case KindMath, KindMathBlock, east.KindTaskCheckBox:
+			feats |= featMath
+		case ast.KindCodeBlock, ast.KindFencedCodeBlock:
+			if r.Config.Macros.CodeBlock.IsZero() {
+				feats |= featListing
+			}
+		case ast.KindBlockquote:
+			if r.Config.Macros.Blockquote.IsZero() && (r.Config.Mode != Beamer || !isNote(source, n)) {
+				feats |= featFramed
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return feats
+}
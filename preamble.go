@@ -0,0 +1,354 @@
+package latex
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PreambleBuilder builds a LaTeX preamble programmatically. Packages are
+// deduplicated. Packages, \hypersetup and raw LaTeX are rendered in the order
+// they were first added, after \documentclass unless the builder was parsed
+// from a preamble with content before it.
+// The zero value renders an article preamble with no packages.
+//
+// To customize the default preamble parse it first:
+//
+//	pb, _ := latex.ParsePreamble(latex.DefaultPreamble())
+//	pb.RemovePackage("helvet")
+//	pb.Geometry("a4paper", "margin=2cm")
+//	cfg := latex.Config{Preamble: pb.Bytes()}
+type PreambleBuilder struct {
+	class        DocumentClass
+	classOptions []string
+	packages     []Package
+	hypersetup   [][2]string
+	// entries is the order in which the preamble is rendered.
+	entries []preambleEntry
+}
+
+// preambleEntry is a part of the preamble: the \documentclass line,
+// a package, the \hypersetup options or raw LaTeX.
+type preambleEntry struct {
+	kind entryKind
+	text string // Package name or raw LaTeX.
+}
+
+type entryKind uint8
+
+const (
+	entryClass entryKind = iota
+	entryPackage
+	entryHypersetup
+	entryRaw
+)
+
+// NewPreambleBuilder returns a PreambleBuilder for the given document class and class options.
+func NewPreambleBuilder(class DocumentClass, options ...string) *PreambleBuilder {
+	pb := &PreambleBuilder{}
+	pb.SetDocumentClass(class, options...)
+	return pb
+}
+
+// SetDocumentClass sets the document class and its options.
+func (pb *PreambleBuilder) SetDocumentClass(class DocumentClass, options ...string) {
+	pb.class = class
+	pb.classOptions = append([]string(nil), options...)
+}
+
+// FontSize sets the base font size class option, i.e. "11pt", replacing any previous size.
+func (pb *PreambleBuilder) FontSize(size string) {
+	opts := pb.classOptions[:0]
+	for _, opt := range pb.classOptions {
+		if !isFontSize(opt) {
+			opts = append(opts, opt)
+		}
+	}
+	pb.classOptions = append(opts, size)
+}
+
+func isFontSize(opt string) bool {
+	size := strings.TrimSuffix(opt, "pt")
+	return len(size) > 0 && len(size) < len(opt) && strings.Trim(size, "0123456789") == ""
+}
+
+// UsePackage loads a package with the given options. If the package is
+// already loaded the options are merged. An error is returned if an option
+// sets a key to a different value than an option already in use, i.e.
+// "margin=1in" and "margin=2cm".
+func (pb *PreambleBuilder) UsePackage(name string, options ...string) error {
+	i := pb.packageIndex(name)
+	if i < 0 {
+		pb.packages = append(pb.packages, Package{Name: name, Options: dedupOptions(options)})
+		pb.entries = append(pb.entries, preambleEntry{kind: entryPackage, text: name})
+		return nil
+	}
+	pkg := &pb.packages[i]
+	for _, opt := range options {
+		key, _, _ := strings.Cut(opt, "=")
+		dup := false
+		for _, existing := range pkg.Options {
+			existingKey, _, _ := strings.Cut(existing, "=")
+			if existing == opt {
+				dup = true
+			} else if key == existingKey {
+				return fmt.Errorf("goldmark-latex: option %q clashes with %q for package %s", opt, existing, name)
+			}
+		}
+		if !dup {
+			pkg.Options = append(pkg.Options, opt)
+		}
+	}
+	return nil
+}
+
+// RemovePackage removes a package. It does nothing if the package is not loaded.
+// Removing hyperref also removes the \hypersetup options.
+func (pb *PreambleBuilder) RemovePackage(name string) {
+	if i := pb.packageIndex(name); i >= 0 {
+		pb.packages = append(pb.packages[:i], pb.packages[i+1:]...)
+	}
+	hyperref := name == "hyperref"
+	if hyperref {
+		pb.hypersetup = nil
+	}
+	entries := pb.entries[:0]
+	for _, e := range pb.entries {
+		if (e.kind == entryPackage && e.text == name) || (hyperref && e.kind == entryHypersetup) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	pb.entries = entries
+}
+
+// Packages returns a copy of the packages loaded.
+func (pb *PreambleBuilder) Packages() []Package {
+	return append([]Package(nil), pb.packages...)
+}
+
+// Geometry loads the geometry package replacing any previous options, i.e. "margin=1in".
+func (pb *PreambleBuilder) Geometry(options ...string) {
+	if i := pb.packageIndex("geometry"); i >= 0 {
+		pb.packages[i].Options = dedupOptions(options)
+		return
+	}
+	pb.UsePackage("geometry", options...)
+}
+
+// Hypersetup sets a hyperref option. Setting an existing key replaces its value.
+// Loads the hyperref package if not already loaded.
+func (pb *PreambleBuilder) Hypersetup(key, value string) {
+	if pb.packageIndex("hyperref") < 0 {
+		pb.UsePackage("hyperref")
+	}
+	for i := range pb.hypersetup {
+		if pb.hypersetup[i][0] == key {
+			pb.hypersetup[i][1] = value
+			return
+		}
+	}
+	if pb.hypersetup == nil {
+		pb.entries = append(pb.entries, preambleEntry{kind: entryHypersetup})
+	}
+	pb.hypersetup = append(pb.hypersetup, [2]string{key, value})
+}
+
+// AddRaw adds raw LaTeX written after the packages and \hypersetup added so far.
+func (pb *PreambleBuilder) AddRaw(raw string) {
+	pb.entries = append(pb.entries, preambleEntry{kind: entryRaw, text: raw})
+}
+
+// Bytes renders the preamble. The result can be used as Config.Preamble.
+func (pb *PreambleBuilder) Bytes() []byte {
+	var b bytes.Buffer
+	entries := pb.entries
+	if !pb.hasEntry(entryClass) {
+		entries = append([]preambleEntry{{kind: entryClass}}, entries...)
+	}
+	for i, e := range entries {
+		if i > 0 && (e.kind != entryPackage || entries[i-1].kind != entryPackage) {
+			// Separate everything but consecutive packages by a blank line.
+			b.WriteByte('\n')
+		}
+		switch e.kind {
+		case entryClass:
+			class := pb.class
+			if class == "" {
+				class = ClassArticle
+			}
+			b.WriteString("\\documentclass")
+			if len(pb.classOptions) > 0 {
+				b.WriteString("[" + strings.Join(pb.classOptions, ",") + "]")
+			}
+			b.WriteString("{" + string(class) + "}\n")
+		case entryPackage:
+			b.WriteString(pb.packages[pb.packageIndex(e.text)].String())
+			b.WriteByte('\n')
+		case entryHypersetup:
+			b.WriteString("\\hypersetup{")
+			for i, kv := range pb.hypersetup {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				b.WriteString("%\n  ")
+				b.WriteString(kv[0])
+				if kv[1] != "" {
+					b.WriteString("=" + kv[1])
+				}
+			}
+			b.WriteString("}\n")
+		case entryRaw:
+			b.WriteString(e.text)
+			if !strings.HasSuffix(e.text, "\n") {
+				b.WriteByte('\n')
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// String renders the preamble.
+func (pb *PreambleBuilder) String() string { return string(pb.Bytes()) }
+
+func (pb *PreambleBuilder) hasEntry(kind entryKind) bool {
+	for _, e := range pb.entries {
+		if e.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (pb *PreambleBuilder) packageIndex(name string) int {
+	for i := range pb.packages {
+		if pb.packages[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ParsePreamble returns a PreambleBuilder initialized with the contents of
+// a preamble such as the one returned by DefaultPreamble. \documentclass,
+// \usepackage and \hypersetup commands at the start of a line are parsed,
+// everything else is kept as raw LaTeX in its place relative to them.
+func ParsePreamble(preamble []byte) (*PreambleBuilder, error) {
+	pb := &PreambleBuilder{}
+	var raw strings.Builder
+	flushRaw := func() {
+		if s := strings.TrimSpace(raw.String()); s != "" {
+			pb.AddRaw(s + "\n")
+		}
+		raw.Reset()
+	}
+	lines := strings.SplitAfter(string(preamble), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(stripComment(lines[i]))
+		switch {
+		case strings.HasPrefix(line, "\\documentclass"):
+			opts, args, err := parseCommand(line, "\\documentclass")
+			if err != nil {
+				return nil, err
+			}
+			flushRaw()
+			pb.SetDocumentClass(DocumentClass(args), opts...)
+			if !pb.hasEntry(entryClass) {
+				pb.entries = append(pb.entries, preambleEntry{kind: entryClass})
+			}
+		case strings.HasPrefix(line, "\\usepackage"):
+			flushRaw()
+			opts, args, err := parseCommand(line, "\\usepackage")
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range splitList(args) {
+				err = pb.UsePackage(name, opts...)
+				if err != nil {
+					return nil, err
+				}
+			}
+		case strings.HasPrefix(line, "\\hypersetup"):
+			flushRaw()
+			// Options may span several lines.
+			for strings.Count(line, "{") > strings.Count(line, "}") && i+1 < len(lines) {
+				i++
+				line += strings.TrimSpace(stripComment(lines[i]))
+			}
+			_, args, err := parseCommand(line, "\\hypersetup")
+			if err != nil {
+				return nil, err
+			}
+			for _, opt := range splitList(args) {
+				key, value, _ := strings.Cut(opt, "=")
+				pb.Hypersetup(strings.TrimSpace(key), strings.TrimSpace(value))
+			}
+		default:
+			raw.WriteString(lines[i])
+		}
+	}
+	flushRaw()
+	return pb, nil
+}
+
+// parseCommand parses a command of the form \cmd[options]{argument}.
+func parseCommand(line, cmd string) (options []string, arg string, err error) {
+	rest := strings.TrimSpace(line[len(cmd):])
+	if strings.HasPrefix(rest, "[") {
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return nil, "", errors.New("goldmark-latex: unterminated options in " + line)
+		}
+		options = splitList(rest[1:end])
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+	if !strings.HasPrefix(rest, "{") || !strings.HasSuffix(rest, "}") {
+		return nil, "", errors.New("goldmark-latex: unable to parse " + line)
+	}
+	return options, rest[1 : len(rest)-1], nil
+}
+
+// splitList splits a comma separated list, trimming spaces and omitting empty elements.
+// Commas within braces do not split the list.
+func splitList(s string) (list []string) {
+	depth, start := 0, 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && s[i] == '{' {
+			depth++
+		} else if i < len(s) && s[i] == '}' {
+			depth--
+		} else if i == len(s) || (s[i] == ',' && depth == 0) {
+			if elem := strings.TrimSpace(s[start:i]); elem != "" {
+				list = append(list, elem)
+			}
+			start = i + 1
+		}
+	}
+	return list
+}
+
+// stripComment removes a trailing LaTeX comment from line.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++ // Skip escaped character.
+		} else if line[i] == '%' {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func dedupOptions(options []string) (opts []string) {
+	for _, opt := range options {
+		dup := false
+		for _, o := range opts {
+			dup = dup || o == opt
+		}
+		if !dup {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
@@ -0,0 +1,141 @@
+package latex
+
+import (
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// scriptFeatures returns the script features of the non-ASCII text in source.
+func scriptFeatures(source []byte) (feats feature) {
+	for i := 0; i < len(source); {
+		c, size := utf8.DecodeRune(source[i:])
+		i += size
+		switch {
+		case size == 1:
+		case isCJK(c):
+			feats |= featCJK
+		case rtlScript(c) != "":
+			feats |= featRTL
+		}
+	}
+	return feats
+}
+
+func isCJK(c rune) bool {
+	return unicode.In(c, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// rtlScript returns the polyglossia language of a right-to-left script
+// character or an empty string if c is not one.
+func rtlScript(c rune) string {
+	switch {
+	case unicode.Is(unicode.Hebrew, c):
+		return "hebrew"
+	case unicode.Is(unicode.Arabic, c):
+		return "arabic"
+	}
+	return ""
+}
+
+// rtlLanguages returns the languages of the right-to-left scripts
+// used in source in order of appearance.
+func rtlLanguages(source []byte) (langs []string) {
+	for i := 0; i < len(source); {
+		c, size := utf8.DecodeRune(source[i:])
+		i += size
+		lang := rtlScript(c)
+		if lang == "" {
+			continue
+		}
+		found := false
+		for _, l := range langs {
+			found = found || l == lang
+		}
+		if !found {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// cjkFamily returns the CJKutf8 font family for the CJK text in source.
+func (r *Renderer) cjkFamily(source []byte) string {
+	if r.Config.Fonts.CJK != "" {
+		return r.Config.Fonts.CJK
+	}
+	family := "gbsn" // Simplified Chinese.
+	for i := 0; i < len(source); {
+		c, size := utf8.DecodeRune(source[i:])
+		i += size
+		if unicode.In(c, unicode.Hiragana, unicode.Katakana) {
+			return "min"
+		} else if unicode.Is(unicode.Hangul, c) {
+			family = "mj"
+		}
+	}
+	return family
+}
+
+// writeCJKSetup writes the preamble lines that configure the packages loaded for CJK text.
+func (r *Renderer) writeCJKSetup(w io.Writer, feats feature) {
+	if feats&featCJK == 0 || r.Config.Fonts.CJK == "" {
+		return
+	}
+	switch r.Config.Engine {
+	case XeLaTeX:
+		_, _ = io.WriteString(w, "\n\\setCJKmainfont{"+r.Config.Fonts.CJK+"}\n")
+	case LuaLaTeX:
+		_, _ = io.WriteString(w, "\n\\setmainjfont{"+r.Config.Fonts.CJK+"}\n")
+	}
+}
+
+// writeCJKStart starts the CJK environment required by CJKutf8 around the document body.
+func (r *Renderer) writeCJKStart(w io.Writer, source []byte) {
+	if !r.Config.Engine.Unicode() && scriptFeatures(source)&featCJK != 0 {
+		_, _ = io.WriteString(w, "\\begin{CJK}{UTF8}{"+r.cjkFamily(source)+"}\n")
+	}
+}
+
+func (r *Renderer) writeCJKEnd(w io.Writer, source []byte) {
+	if !r.Config.Engine.Unicode() && scriptFeatures(source)&featCJK != 0 {
+		_, _ = io.WriteString(w, "\n\\end{CJK}\n")
+	}
+}
+
+// escapeScripts escapes text wrapping runs of right-to-left text in
+// polyglossia commands such as \texthebrew.
+func escapeScripts(w io.Writer, text []byte) {
+	start := 0    // Start of text not yet written.
+	runStart := 0 // Start of the current right-to-left run.
+	runEnd := -1  // End of the last right-to-left character of the run, -1 if no run.
+	lang := ""
+	flush := func() {
+		if runEnd < 0 {
+			return
+		}
+		escapeLaTeX(w, text[start:runStart])
+		_, _ = io.WriteString(w, "\\text"+lang+"{")
+		escapeLaTeX(w, text[runStart:runEnd])
+		_, _ = io.WriteString(w, "}")
+		start, runEnd, lang = runEnd, -1, ""
+	}
+	for i := 0; i < len(text); {
+		c, size := utf8.DecodeRune(text[i:])
+		script := rtlScript(c)
+		switch {
+		case script != "" && script == lang:
+			runEnd = i + size
+		case script != "":
+			flush()
+			lang, runStart, runEnd = script, i, i+size
+		case unicode.IsLetter(c):
+			// Spaces, digits and punctuation between right-to-left characters
+			// belong to the run, letters of other scripts end it.
+			flush()
+		}
+		i += size
+	}
+	flush()
+	escapeLaTeX(w, text[start:])
+}
@@ -0,0 +1,114 @@
+package latex
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/yuin/goldmark/util"
+)
+
+// lineMarker starts the comments written before each block when Config.LineMarkers is set.
+var lineMarker = []byte("%!md:")
+
+// SourceMap maps lines of LaTeX output to positions in the markdown source.
+// It is usually saved as JSON next to the .tex file.
+type SourceMap struct {
+	// Name of the markdown source file, if known.
+	Source string `json:"source,omitempty"`
+	// Mappings sorted by TeXLine.
+	Mappings []LineMapping `json:"mappings"`
+}
+
+// LineMapping maps a line of LaTeX output, and the lines following it up to the
+// next mapping, to a position in the markdown source. Lines and columns start at 1.
+type LineMapping struct {
+	TeXLine int `json:"tex"`
+	Line    int `json:"line"`
+	Column  int `json:"column"`
+}
+
+// Lookup returns the markdown position the LaTeX output line texLine was rendered from.
+func (sm SourceMap) Lookup(texLine int) (line, column int, ok bool) {
+	for i := len(sm.Mappings) - 1; i >= 0; i-- {
+		if m := sm.Mappings[i]; m.TeXLine <= texLine {
+			return m.Line, m.Column, true
+		}
+	}
+	return 0, 0, false
+}
+
+// ExtractSourceMap returns the source map of LaTeX rendered with Config.LineMarkers.
+// If keepMarkers is false the markers are removed from the returned LaTeX and the source
+// map refers to its lines, otherwise tex is returned as is.
+func ExtractSourceMap(tex []byte, keepMarkers bool) ([]byte, SourceMap) {
+	var sm SourceMap
+	var out []byte
+	var pending *LineMapping // Position of the next line with text.
+	texLine := 1
+	emit := func(text []byte) {
+		if pending != nil && len(bytes.TrimSpace(text)) > 0 {
+			pending.TeXLine = texLine
+			if n := len(sm.Mappings); n > 0 && sm.Mappings[n-1].TeXLine >= texLine {
+				// Line joined with the previous block keeps its position.
+				pending.TeXLine = sm.Mappings[n-1].TeXLine + 1
+			}
+			sm.Mappings = append(sm.Mappings, *pending)
+			pending = nil
+		}
+		out = append(out, text...)
+		texLine += bytes.Count(text, []byte{'\n'})
+	}
+	for len(tex) > 0 {
+		line := tex
+		if i := bytes.IndexByte(tex, '\n'); i >= 0 {
+			line = tex[:i+1]
+		}
+		tex = tex[len(line):]
+		i := bytes.Index(line, lineMarker)
+		mdLine, mdCol, ok := parseLineMarker(line, i)
+		if !ok {
+			emit(line)
+			continue
+		}
+		// Without the marker, text before it is joined with the next line as TeX does.
+		emit(line[:i])
+		if keepMarkers {
+			out = append(out, line[i:]...)
+			texLine++
+		}
+		pending = &LineMapping{Line: mdLine, Column: mdCol}
+	}
+	return out, sm
+}
+
+// parseLineMarker parses the line marker at index i of line, which must be
+// the last thing on the line.
+func parseLineMarker(line []byte, i int) (mdLine, mdCol int, ok bool) {
+	if i < 0 {
+		return 0, 0, false
+	}
+	pos := bytes.TrimRight(line[i+len(lineMarker):], "\r\n")
+	l, c, found := bytes.Cut(pos, []byte{':'})
+	if !found {
+		return 0, 0, false
+	}
+	mdLine, err := strconv.Atoi(string(l))
+	if err != nil {
+		return 0, 0, false
+	}
+	mdCol, err = strconv.Atoi(string(c))
+	return mdLine, mdCol, err == nil
+}
+
+// writeLineMarker writes the line marker of a block starting at offset in source.
+func writeLineMarker(w util.BufWriter, source []byte, offset int) {
+	line, column := position(source, offset)
+	if line == 0 {
+		return
+	}
+	_, _ = w.Write(lineMarker)
+	_, _ = w.WriteString(strconv.Itoa(line))
+	_ = w.WriteByte(':')
+	_, _ = w.WriteString(strconv.Itoa(column))
+	_ = w.WriteByte('\n')
+}
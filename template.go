@@ -0,0 +1,224 @@
+package latex
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+//go:embed defaultTemplate.tex
+var defaultTemplateText string
+
+var defaultTemplate = template.Must(ParseTemplate(defaultTemplateText))
+
+//go:embed beamerPreamble.tex
+var beamerPreambleText string
+
+// beamerTemplate renders the default beamer preamble. It shares the
+// fonts template with the default template.
+var beamerTemplate = template.Must(template.Must(defaultTemplate.Clone()).New("beamer").Parse(beamerPreambleText))
+
+// bodyMarker stands in for the document body when splitting the executed template.
+const bodyMarker = "\x00goldmark-latex-body\x00"
+
+// TemplateData is the data a document template is executed with.
+// Text fields are valid LaTeX, already escaped.
+type TemplateData struct {
+	// Rendered document body.
+	Body string
+	// Title, authors and date taken from the document metadata keys
+	// "title", "author" (or "authors") and "date".
+	Title   string
+	Authors []string
+	Date    string
+	// Document metadata as set by a front matter parser. Values are not escaped,
+	// use the escape template function when writing them.
+	Meta map[string]interface{}
+	// Packages loaded by the default preamble. Only the packages used by
+	// the document are included unless Config.FullPreamble is set.
+	Packages []Package
+	// Typeset a table of contents. Set by Config.TOC or the "toc" metadata key.
+	TOC           bool
+	DocumentClass DocumentClass
+	// Main language of the document as a babel or polyglossia language name.
+	// Empty if not set.
+	Language string
+	// TeX engine the document is typeset with and fonts set for Unicode engines.
+	Engine Engine
+	Fonts  Fonts
+	// Replaces the default preamble when not empty. Set from Config.Preamble or
+	// to the beamer preamble in Beamer mode.
+	Preamble string
+	// Preamble lines generated by the renderer such as unicode character declarations.
+	HeaderIncludes string
+}
+
+// HasPackage reports whether the named package is in Packages.
+func (data TemplateData) HasPackage(name string) bool {
+	for _, pkg := range data.Packages {
+		if pkg.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTemplate parses a text/template document template. Besides the
+// text/template builtins templates can call escape, which escapes LaTeX
+// special characters, and join, which is strings.Join.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("latex").Funcs(template.FuncMap{
+		"escape": Escape,
+		"join":   strings.Join,
+	}).Parse(text)
+}
+
+// TemplateData returns the data the document template is executed with when rendering doc,
+// with the exception of Body. doc may be nil, in which case no metadata is set.
+func (r *Renderer) TemplateData(doc ast.Node, source []byte) TemplateData {
+	data := TemplateData{
+		TOC:           r.Config.TOC,
+		DocumentClass: r.Config.DocumentClass,
+		Preamble:      string(r.Config.Preamble),
+		Engine:        r.Config.Engine,
+		Fonts:         r.Config.Fonts,
+	}
+	if data.DocumentClass == "" {
+		data.DocumentClass = ClassArticle
+	}
+	if data.Engine == "" {
+		data.Engine = PDFLaTeX
+	}
+	if r.Config.Mode == Beamer {
+		data.DocumentClass = "beamer"
+		if data.Preamble == "" {
+			data.Preamble = r.beamerPreamble(data)
+		}
+	}
+	if d, ok := doc.(*ast.Document); ok {
+		data.Meta = d.Meta()
+	}
+	if title := metaStrings(data.Meta["title"]); len(title) > 0 {
+		data.Title = Escape(title[0])
+	}
+	if date := metaStrings(data.Meta["date"]); len(date) > 0 {
+		data.Date = Escape(date[0])
+	}
+	authors := metaStrings(data.Meta["author"])
+	if authors == nil {
+		authors = metaStrings(data.Meta["authors"])
+	}
+	for _, author := range authors {
+		data.Authors = append(data.Authors, Escape(author))
+	}
+	if toc, ok := data.Meta["toc"].(bool); ok {
+		data.TOC = toc
+	}
+	var b strings.Builder
+	feats := r.features(doc, source)
+	if r.Config.FullPreamble {
+		feats |= ^(featEngines | featScripts)
+	}
+	langs := r.languages(doc, source)
+	data.Language = langs.main
+	langPkg := langs.Package(data.Engine)
+	var required []Package
+	switch {
+	case r.Config.Preamble != nil:
+		required = packagesFor(feats)
+	case r.Config.Mode == Beamer:
+		// The beamer preamble loads its own packages.
+	default:
+		data.Packages = preamblePackages(feats, langPkg)
+	}
+	if langPkg.Name != "" && data.Packages == nil {
+		required = append(required, langPkg)
+	}
+	for _, pkg := range missingPackages([]byte(data.Preamble), required) {
+		if r.Config.Preamble != nil {
+			b.WriteString("\n% goldmark-latex: package " + pkg.Name + " is used by the document but missing from the preamble, adding it.")
+			r.diagnose(nil, nil, SeverityInfo, CodeMissingPackage, "package "+pkg.Name+" is used by the document but missing from the preamble, adding it")
+		}
+		b.WriteString("\n" + pkg.String() + "\n")
+	}
+	r.writeLanguageSetup(&b, feats, langs)
+	r.writeCJKSetup(&b, feats)
+	if r.Config.DeclareUnicode != nil && !data.Engine.Unicode() {
+		// Unicode engines error on \DeclareUnicodeCharacter.
+		r.writeUnicodeDeclarations(&b, source)
+	}
+	data.HeaderIncludes = b.String()
+	return data
+}
+
+// beamerPreamble returns the default beamer preamble for data.
+func (r *Renderer) beamerPreamble(data TemplateData) string {
+	var b strings.Builder
+	err := beamerTemplate.ExecuteTemplate(&b, "beamer", data)
+	if err != nil {
+		panic(err) // The embedded template is known to execute.
+	}
+	if r.Config.BeamerTheme != "" {
+		b.WriteString("\n\\usetheme{" + r.Config.BeamerTheme + "}\n")
+	}
+	return b.String()
+}
+
+// ExecuteTemplate writes the document template executed with data to w.
+// Config.Template is used if set, otherwise the default template.
+func (r *Renderer) ExecuteTemplate(w io.Writer, data TemplateData) error {
+	tmpl := r.Config.Template
+	if tmpl == nil {
+		tmpl = defaultTemplate
+	}
+	return tmpl.Execute(w, data)
+}
+
+// documentTemplate returns the output of the document template
+// before and after the body of doc.
+func (r *Renderer) documentTemplate(doc ast.Node, source []byte) (head, tail string, err error) {
+	data := r.TemplateData(doc, source)
+	data.Body = bodyMarker
+	var b strings.Builder
+	err = r.ExecuteTemplate(&b, data)
+	if err != nil {
+		return "", "", err
+	}
+	head, tail, found := strings.Cut(b.String(), bodyMarker)
+	if !found {
+		return "", "", errors.New("goldmark-latex: document template does not write {{.Body}}")
+	}
+	return head, tail, nil
+}
+
+// metaStrings converts a metadata value to a list of strings.
+func metaStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		s := make([]string, len(v))
+		for i := range v {
+			s[i] = fmt.Sprint(v[i])
+		}
+		return s
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+// Escape returns s with the ASCII characters special to LaTeX escaped the way
+// the Renderer escapes text, e.g. for user input written into a template.
+func Escape(s string) string {
+	var b strings.Builder
+	escapeLaTeX(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,438 @@
+package latex
+
+import (
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// UnicodeToLaTeX returns LaTeX that typesets r with pdflatex using the
+// packages of the default preamble. It covers Latin letters with accents,
+// Greek letters, common math symbols, arrows, typographic punctuation,
+// currency and box-drawing characters. It can be used as Config.DeclareUnicode:
+//
+//	cfg := latex.Config{DeclareUnicode: latex.UnicodeToLaTeX}
+func UnicodeToLaTeX(r rune) (raw string, isReplaced bool) {
+	if raw, ok := unicodeTable[r]; ok {
+		return raw, true
+	}
+	return accented(r)
+}
+
+// UnmappedRunes returns the distinct non-ASCII characters of text in order
+// of appearance that UnicodeToLaTeX can not replace. CJK characters, which are
+// typeset by the CJK support of the Renderer, are not returned.
+// text is normalized to NFC first.
+func UnmappedRunes(text []byte) (unmapped []rune) {
+	seen := make(map[rune]bool)
+	text = norm.NFC.Bytes(text)
+	for i := 0; i < len(text); {
+		c, size := utf8.DecodeRune(text[i:])
+		i += size
+		if size == 1 || seen[c] || isCJK(c) {
+			continue
+		}
+		seen[c] = true
+		if _, ok := UnicodeToLaTeX(c); !ok {
+			unmapped = append(unmapped, c)
+		}
+	}
+	return unmapped
+}
+
+// accented returns the LaTeX accent commands for a precomposed Latin letter
+// by decomposing it into its base letter and combining marks.
+func accented(r rune) (string, bool) {
+	var buf [utf8.UTFMax]byte
+	decomposed := norm.NFD.Bytes(buf[:utf8.EncodeRune(buf[:], r)])
+	if len(decomposed) < 2 || decomposed[0] >= utf8.RuneSelf {
+		return "", false
+	}
+	base := string(decomposed[:1])
+	switch base {
+	case "i":
+		base = "\\i{}" // Dotless i, so that the accent replaces the dot.
+	case "j":
+		base = "\\j{}"
+	}
+	for i := 1; i < len(decomposed); {
+		mark, size := utf8.DecodeRune(decomposed[i:])
+		i += size
+		cmd, ok := accentTable[mark]
+		if !ok {
+			return "", false
+		}
+		base = cmd + "{" + base + "}"
+	}
+	return base, true
+}
+
+// escapeText escapes text for LaTeX, applying NFC normalization and replacing
+// non-ASCII characters inline when configured to.
+func (r *Renderer) escapeText(w io.Writer, text []byte) {
+	if r.Config.Engine.Unicode() {
+		escapeScripts(w, text)
+		return
+	}
+	if r.Config.DeclareUnicode == nil {
+		escapeLaTeX(w, text)
+		return
+	}
+	if !norm.NFC.IsNormal(text) {
+		// Combine characters so that declared replacements match.
+		text = norm.NFC.Bytes(text)
+	}
+	if !r.inlineUnicode() {
+		escapeLaTeX(w, text)
+		return
+	}
+	start := 0
+	for i := 0; i < len(text); {
+		c, size := utf8.DecodeRune(text[i:])
+		if size > 1 {
+			if replace, ok := r.Config.DeclareUnicode(c); ok {
+				escapeLaTeX(w, text[start:i])
+				_, _ = io.WriteString(w, replace)
+				start = i + size
+			}
+		}
+		i += size
+	}
+	escapeLaTeX(w, text[start:])
+}
+
+// inlineUnicode reports whether non-ASCII characters are replaced inline
+// instead of declared in the preamble.
+func (r *Renderer) inlineUnicode() bool {
+	return r.Config.InlineUnicode || r.Config.Fragment
+}
+
+// declaresAMSSymbols reports whether Config.DeclareUnicode replaces a
+// character of source with a command of the amssymb package, e.g. ✓ with \checkmark.
+func (r *Renderer) declaresAMSSymbols(source []byte) bool {
+	seen := make(map[rune]bool)
+	for i := 0; i < len(source); {
+		c, size := utf8.DecodeRune(source[i:])
+		i += size
+		if size == 1 || seen[c] {
+			continue
+		}
+		seen[c] = true
+		if replace, ok := r.Config.DeclareUnicode(c); ok && usesCommand(replace, amssymbCommands) {
+			return true
+		}
+	}
+	return false
+}
+
+// usesCommand reports whether the LaTeX in raw uses a command in commands.
+func usesCommand(raw string, commands map[string]bool) bool {
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			continue
+		}
+		end := i + 1
+		for end < len(raw) && (raw[end] >= 'a' && raw[end] <= 'z' || raw[end] >= 'A' && raw[end] <= 'Z') {
+			end++
+		}
+		if commands[raw[i+1:end]] {
+			return true
+		}
+		i = end - 1
+	}
+	return false
+}
+
+// amssymbCommands are the commands of mathCommands defined by amssymb.
+var amssymbCommands = wordSet(`
+	checkmark nexists varnothing square blacksquare therefore because complement
+	lesssim gtrsim leqslant geqslant lll ggg triangleq nleq ngeq subsetneq supsetneq
+	smallsetminus nmid varkappa digamma beth gimel daleth
+`)
+
+// accentTable maps combining diacritical marks to LaTeX accent commands.
+var accentTable = map[rune]string{
+	'\u0300': "\\`",
+	'\u0301': "\\'",
+	'\u0302': "\\^",
+	'\u0303': "\\~",
+	'\u0304': "\\=",
+	'\u0306': "\\u",
+	'\u0307': "\\.",
+	'\u0308': "\\\"",
+	'\u030a': "\\r",
+	'\u030b': "\\H",
+	'\u030c': "\\v",
+	'\u0323': "\\d",
+	'\u0327': "\\c",
+	'\u0328': "\\k",
+	'\u0331': "\\b",
+}
+
+// unicodeTable maps characters that are not composed of a Latin letter
+// and accents to LaTeX.
+var unicodeTable = map[rune]string{
+	// Latin letters.
+	'ß': "\\ss{}",
+	'æ': "\\ae{}",
+	'Æ': "\\AE{}",
+	'œ': "\\oe{}",
+	'Œ': "\\OE{}",
+	'ø': "\\o{}",
+	'Ø': "\\O{}",
+	'ł': "\\l{}",
+	'Ł': "\\L{}",
+	'ı': "\\i{}",
+	'ȷ': "\\j{}",
+	'đ': "\\dj{}",
+	'Đ': "\\DJ{}",
+	'ð': "\\dh{}",
+	'Ð': "\\DH{}",
+	'þ': "\\th{}",
+	'Þ': "\\TH{}",
+	'ŋ': "\\ng{}",
+	'Ŋ': "\\NG{}",
+
+	// Greek letters.
+	'α':      "\\ensuremath{\\alpha}",
+	'β':      "\\ensuremath{\\beta}",
+	'γ':      "\\ensuremath{\\gamma}",
+	'δ':      "\\ensuremath{\\delta}",
+	'ε':      "\\ensuremath{\\varepsilon}",
+	'ϵ':      "\\ensuremath{\\epsilon}",
+	'ζ':      "\\ensuremath{\\zeta}",
+	'η':      "\\ensuremath{\\eta}",
+	'θ':      "\\ensuremath{\\theta}",
+	'ϑ':      "\\ensuremath{\\vartheta}",
+	'ι':      "\\ensuremath{\\iota}",
+	'κ':      "\\ensuremath{\\kappa}",
+	'λ':      "\\ensuremath{\\lambda}",
+	'μ':      "\\ensuremath{\\mu}",
+	'µ':      "\\ensuremath{\\mu}", // Micro sign.
+	'ν':      "\\ensuremath{\\nu}",
+	'ξ':      "\\ensuremath{\\xi}",
+	'ο':      "o",
+	'π':      "\\ensuremath{\\pi}",
+	'ϖ':      "\\ensuremath{\\varpi}",
+	'ρ':      "\\ensuremath{\\rho}",
+	'ϱ':      "\\ensuremath{\\varrho}",
+	'σ':      "\\ensuremath{\\sigma}",
+	'ς':      "\\ensuremath{\\varsigma}",
+	'τ':      "\\ensuremath{\\tau}",
+	'υ':      "\\ensuremath{\\upsilon}",
+	'φ':      "\\ensuremath{\\varphi}",
+	'ϕ':      "\\ensuremath{\\phi}",
+	'χ':      "\\ensuremath{\\chi}",
+	'ψ':      "\\ensuremath{\\psi}",
+	'ω':      "\\ensuremath{\\omega}",
+	'Α':      "A",
+	'Β':      "B",
+	'Γ':      "\\ensuremath{\\Gamma}",
+	'Δ':      "\\ensuremath{\\Delta}",
+	'Ε':      "E",
+	'Ζ':      "Z",
+	'Η':      "H",
+	'Θ':      "\\ensuremath{\\Theta}",
+	'Ι':      "I",
+	'Κ':      "K",
+	'Λ':      "\\ensuremath{\\Lambda}",
+	'Μ':      "M",
+	'Ν':      "N",
+	'Ξ':      "\\ensuremath{\\Xi}",
+	'Ο':      "O",
+	'Π':      "\\ensuremath{\\Pi}",
+	'Ρ':      "P",
+	'Σ':      "\\ensuremath{\\Sigma}",
+	'Τ':      "T",
+	'Υ':      "\\ensuremath{\\Upsilon}",
+	'Φ':      "\\ensuremath{\\Phi}",
+	'Χ':      "X",
+	'Ψ':      "\\ensuremath{\\Psi}",
+	'Ω':      "\\ensuremath{\\Omega}",
+	'\u2126': "\\ensuremath{\\Omega}", // Ohm sign.
+
+	// Math symbols.
+	'±': "\\ensuremath{\\pm}",
+	'∓': "\\ensuremath{\\mp}",
+	'×': "\\ensuremath{\\times}",
+	'÷': "\\ensuremath{\\div}",
+	'·': "\\ensuremath{\\cdot}",
+	'⋅': "\\ensuremath{\\cdot}",
+	'∗': "\\ensuremath{\\ast}",
+	'∘': "\\ensuremath{\\circ}",
+	'−': "\\ensuremath{-}",
+	'≤': "\\ensuremath{\\leq}",
+	'≥': "\\ensuremath{\\geq}",
+	'≠': "\\ensuremath{\\neq}",
+	'≈': "\\ensuremath{\\approx}",
+	'≡': "\\ensuremath{\\equiv}",
+	'∼': "\\ensuremath{\\sim}",
+	'≃': "\\ensuremath{\\simeq}",
+	'≅': "\\ensuremath{\\cong}",
+	'∝': "\\ensuremath{\\propto}",
+	'≪': "\\ensuremath{\\ll}",
+	'≫': "\\ensuremath{\\gg}",
+	'∞': "\\ensuremath{\\infty}",
+	'∑': "\\ensuremath{\\sum}",
+	'∏': "\\ensuremath{\\prod}",
+	'∫': "\\ensuremath{\\int}",
+	'∮': "\\ensuremath{\\oint}",
+	'√': "\\ensuremath{\\surd}",
+	'∂': "\\ensuremath{\\partial}",
+	'∇': "\\ensuremath{\\nabla}",
+	'∀': "\\ensuremath{\\forall}",
+	'∃': "\\ensuremath{\\exists}",
+	'∄': "\\ensuremath{\\nexists}",
+	'∅': "\\ensuremath{\\emptyset}",
+	'∈': "\\ensuremath{\\in}",
+	'∉': "\\ensuremath{\\notin}",
+	'∋': "\\ensuremath{\\ni}",
+	'⊂': "\\ensuremath{\\subset}",
+	'⊃': "\\ensuremath{\\supset}",
+	'⊆': "\\ensuremath{\\subseteq}",
+	'⊇': "\\ensuremath{\\supseteq}",
+	'∪': "\\ensuremath{\\cup}",
+	'∩': "\\ensuremath{\\cap}",
+	'∧': "\\ensuremath{\\wedge}",
+	'∨': "\\ensuremath{\\vee}",
+	'¬': "\\ensuremath{\\neg}",
+	'⊕': "\\ensuremath{\\oplus}",
+	'⊗': "\\ensuremath{\\otimes}",
+	'⊥': "\\ensuremath{\\perp}",
+	'∥': "\\ensuremath{\\parallel}",
+	'∠': "\\ensuremath{\\angle}",
+	'′': "\\ensuremath{\\prime}",
+	'″': "\\ensuremath{\\prime\\prime}",
+	'ℓ': "\\ensuremath{\\ell}",
+	'ℵ': "\\ensuremath{\\aleph}",
+	'ℏ': "\\ensuremath{\\hbar}",
+	'℘': "\\ensuremath{\\wp}",
+	'ℑ': "\\ensuremath{\\Im}",
+	'ℜ': "\\ensuremath{\\Re}",
+	'⌈': "\\ensuremath{\\lceil}",
+	'⌉': "\\ensuremath{\\rceil}",
+	'⌊': "\\ensuremath{\\lfloor}",
+	'⌋': "\\ensuremath{\\rfloor}",
+	'⟨': "\\ensuremath{\\langle}",
+	'⟩': "\\ensuremath{\\rangle}",
+	'°': "\\textdegree{}",
+	'¹': "\\textonesuperior{}",
+	'²': "\\texttwosuperior{}",
+	'³': "\\textthreesuperior{}",
+	'¼': "\\textonequarter{}",
+	'½': "\\textonehalf{}",
+	'¾': "\\textthreequarters{}",
+	'‰': "\\textperthousand{}",
+
+	// Arrows.
+	'←': "\\ensuremath{\\leftarrow}",
+	'→': "\\ensuremath{\\rightarrow}",
+	'↑': "\\ensuremath{\\uparrow}",
+	'↓': "\\ensuremath{\\downarrow}",
+	'↔': "\\ensuremath{\\leftrightarrow}",
+	'↕': "\\ensuremath{\\updownarrow}",
+	'↖': "\\ensuremath{\\nwarrow}",
+	'↗': "\\ensuremath{\\nearrow}",
+	'↘': "\\ensuremath{\\searrow}",
+	'↙': "\\ensuremath{\\swarrow}",
+	'↦': "\\ensuremath{\\mapsto}",
+	'↩': "\\ensuremath{\\hookleftarrow}",
+	'↪': "\\ensuremath{\\hookrightarrow}",
+	'⇐': "\\ensuremath{\\Leftarrow}",
+	'⇒': "\\ensuremath{\\Rightarrow}",
+	'⇑': "\\ensuremath{\\Uparrow}",
+	'⇓': "\\ensuremath{\\Downarrow}",
+	'⇔': "\\ensuremath{\\Leftrightarrow}",
+	'⟵': "\\ensuremath{\\longleftarrow}",
+	'⟶': "\\ensuremath{\\longrightarrow}",
+	'⟷': "\\ensuremath{\\longleftrightarrow}",
+	'⟹': "\\ensuremath{\\Longrightarrow}",
+	'⟸': "\\ensuremath{\\Longleftarrow}",
+	'⟼': "\\ensuremath{\\longmapsto}",
+
+	// Typographic punctuation.
+	'\u00a0': "~",   // No-break space.
+	'\u2009': "\\,", // Thin space.
+	'\u200b': "",    // Zero width space.
+	'\u00ad': "\\-", // Soft hyphen.
+	'‐':      "-",
+	'‑':      "\\mbox{-}", // Non-breaking hyphen.
+	'‒':      "--",
+	'–':      "--",
+	'—':      "---",
+	'―':      "---",
+	'‘':      "`",
+	'’':      "'",
+	'‚':      "\\quotesinglbase{}",
+	'“':      "``",
+	'”':      "''",
+	'„':      "\\quotedblbase{}",
+	'«':      "\\guillemotleft{}",
+	'»':      "\\guillemotright{}",
+	'‹':      "\\guilsinglleft{}",
+	'›':      "\\guilsinglright{}",
+	'…':      "\\ldots{}",
+	'•':      "\\textbullet{}",
+	'‣':      "\\textbullet{}",
+	'†':      "\\dag{}",
+	'‡':      "\\ddag{}",
+	'§':      "\\S{}",
+	'¶':      "\\P{}",
+	'©':      "\\copyright{}",
+	'®':      "\\textregistered{}",
+	'™':      "\\texttrademark{}",
+	'¡':      "!`",
+	'¿':      "?`",
+	'¦':      "\\textbrokenbar{}",
+	'№':      "\\textnumero{}",
+	'✓':      "\\checkmark{}",
+
+	// Currency.
+	'€': "\\texteuro{}",
+	'£': "\\pounds{}",
+	'¥': "\\textyen{}",
+	'¢': "\\textcent{}",
+	'¤': "\\textcurrency{}",
+	'₩': "\\textwon{}",
+	'₦': "\\textnaira{}",
+	'₱': "\\textpeso{}",
+	'₡': "\\textcolonmonetary{}",
+	'₤': "\\textlira{}",
+	'฿': "\\textbaht{}",
+	'₫': "\\textdong{}",
+	'ƒ': "\\textflorin{}",
+
+	// Box-drawing characters are approximated with ASCII as the default fonts lack them.
+	'─': "-",
+	'━': "-",
+	'│': "|",
+	'┃': "|",
+	'┌': "+",
+	'┐': "+",
+	'└': "+",
+	'┘': "+",
+	'├': "+",
+	'┤': "+",
+	'┬': "+",
+	'┴': "+",
+	'┼': "+",
+	'╭': "+",
+	'╮': "+",
+	'╯': "+",
+	'╰': "+",
+	'═': "=",
+	'║': "\\textbardbl{}",
+	'╔': "+",
+	'╗': "+",
+	'╚': "+",
+	'╝': "+",
+	'╠': "+",
+	'╣': "+",
+	'╦': "+",
+	'╩': "+",
+	'╬': "+",
+	'░': "\\textperiodcentered{}",
+	'▒': "\\textperiodcentered{}",
+	'█': "\\rule{0.5em}{1em}",
+}